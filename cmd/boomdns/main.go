@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"log"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/quic-go/quic-go"
 	"gopkg.in/yaml.v3"
 
 	"github.com/winspan/boomdns/internal/dns"
@@ -68,6 +70,45 @@ func main() {
 	}
 	go server.ServeTCP(tcpLn)
 
+	// Start encrypted listeners (DoT / DoH / DoQ)
+	var dotLn, dohLn net.Listener
+	var doqLn *quic.Listener
+	if cfg.IsEncryptedListenEnabled() {
+		if cfg.ListenDoT != "" {
+			tlsCfg, err := dns.LoadServerTLSConfig(cfg, "dot")
+			if err != nil {
+				log.Fatalf("dot tls: %v", err)
+			}
+			if dotLn, err = tls.Listen("tcp", cfg.ListenDoT, tlsCfg); err != nil {
+				log.Fatalf("listen dot: %v", err)
+			}
+			go server.ServeDoT(dotLn)
+			log.Printf("dns-over-tls listening on %s", cfg.ListenDoT)
+		}
+		if cfg.ListenDoH != "" {
+			tlsCfg, err := dns.LoadServerTLSConfig(cfg, "h2", "http/1.1")
+			if err != nil {
+				log.Fatalf("doh tls: %v", err)
+			}
+			if dohLn, err = tls.Listen("tcp", cfg.ListenDoH, tlsCfg); err != nil {
+				log.Fatalf("listen doh: %v", err)
+			}
+			go server.ServeDoH(dohLn, cfg.GetDoHPath())
+			log.Printf("dns-over-https listening on %s%s", cfg.ListenDoH, cfg.GetDoHPath())
+		}
+		if cfg.ListenDoQ != "" {
+			tlsCfg, err := dns.LoadServerTLSConfig(cfg, "doq")
+			if err != nil {
+				log.Fatalf("doq tls: %v", err)
+			}
+			if doqLn, err = quic.ListenAddr(cfg.ListenDoQ, tlsCfg, &quic.Config{Allow0RTT: true}); err != nil {
+				log.Fatalf("listen doq: %v", err)
+			}
+			go server.ServeDoQ(doqLn)
+			log.Printf("dns-over-quic listening on %s", cfg.ListenDoQ)
+		}
+	}
+
 	// Admin HTTP
 	r := chi.NewRouter()
 
@@ -1870,6 +1911,8 @@ func main() {
 	// 暂时注释掉 admin 路由，因为需要重新实现
 	// admin.BindRoutes(r, server, cfg)
 	r.Handle("/metrics", promhttp.Handler())
+	// DoH 同时挂载到管理端口，便于经反向代理提供 HTTPS
+	r.Handle(cfg.GetDoHPath(), server.DoHHandler())
 
 	httpSrv := &http.Server{
 		Addr:              cfg.ListenHTTP,
@@ -1921,6 +1964,15 @@ func main() {
 			_ = httpSrv.Close()
 			_ = udpConn.Close()
 			_ = tcpLn.Close()
+			if dotLn != nil {
+				_ = dotLn.Close()
+			}
+			if dohLn != nil {
+				_ = dohLn.Close()
+			}
+			if doqLn != nil {
+				_ = doqLn.Close()
+			}
 			return
 		}
 	}
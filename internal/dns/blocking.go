@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// 拦截模式
+const (
+	BlockModeNXDomain = "nxdomain"  // 返回 NXDOMAIN
+	BlockModeRefused  = "refused"   // 返回 REFUSED
+	BlockModeNoData   = "nodata"    // 返回 NOERROR 空应答
+	BlockModeNull     = "null"      // A 返回 0.0.0.0，AAAA 返回 ::
+	BlockModeCustomIP = "custom_ip" // A / AAAA 返回配置的黑洞地址
+	BlockModeUpstream = "upstream"  // 转发到 upstreams.adguard（未配置时按 null 处理）
+)
+
+// defaultBlockTTL 拦截应答默认 TTL
+const defaultBlockTTL = 300
+
+// IsValidBlockMode 判断拦截模式是否有效
+func IsValidBlockMode(mode string) bool {
+	switch mode {
+	case BlockModeNXDomain, BlockModeRefused, BlockModeNoData, BlockModeNull, BlockModeCustomIP, BlockModeUpstream:
+		return true
+	}
+	return false
+}
+
+// blockResponse 在本地合成拦截应答
+func (s *Server) blockResponse(req *mdns.Msg, mode string) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = true
+	q := req.Question[0]
+	ttl := s.cfg.GetBlockingTTL()
+
+	switch mode {
+	case BlockModeRefused:
+		m.Rcode = mdns.RcodeRefused
+		return m
+	case BlockModeNXDomain:
+		m.Rcode = mdns.RcodeNameError
+		m.Ns = []mdns.RR{blockSOA(q.Name, ttl)}
+		return m
+	case BlockModeNoData:
+		m.Ns = []mdns.RR{blockSOA(q.Name, ttl)}
+		return m
+	}
+
+	// null / custom_ip：仅对 A / AAAA 返回地址，其余类型按 NODATA 处理
+	v4, v6 := net.IPv4zero, net.IPv6zero
+	if mode == BlockModeCustomIP {
+		if ip := net.ParseIP(s.cfg.Blocking.IPv4); ip != nil && ip.To4() != nil {
+			v4 = ip.To4()
+		}
+		if ip := net.ParseIP(s.cfg.Blocking.IPv6); ip != nil && ip.To4() == nil {
+			v6 = ip
+		}
+	}
+	hdr := mdns.RR_Header{Name: q.Name, Class: mdns.ClassINET, Ttl: ttl}
+	switch q.Qtype {
+	case mdns.TypeA:
+		hdr.Rrtype = mdns.TypeA
+		m.Answer = []mdns.RR{&mdns.A{Hdr: hdr, A: v4}}
+	case mdns.TypeAAAA:
+		hdr.Rrtype = mdns.TypeAAAA
+		m.Answer = []mdns.RR{&mdns.AAAA{Hdr: hdr, AAAA: v6}}
+	default:
+		m.Ns = []mdns.RR{blockSOA(q.Name, ttl)}
+	}
+	return m
+}
+
+// blockSOA 合成权威 SOA 记录，其 minimum 字段决定客户端的否定缓存时间 (RFC 2308)
+func blockSOA(qname string, ttl uint32) mdns.RR {
+	zone := mdns.Fqdn(strings.ToLower(qname))
+	return &mdns.SOA{
+		Hdr:     mdns.RR_Header{Name: zone, Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: ttl},
+		Ns:      "fake-for-negative-caching.boomdns.",
+		Mbox:    "hostmaster." + zone,
+		Serial:  1,
+		Refresh: 1800,
+		Retry:   900,
+		Expire:  604800,
+		Minttl:  ttl,
+	}
+}
+
+// adsRoute 广告拦截判断，先于缓存执行，规则变更后立即生效。
+// 命中时默认本地合成应答（返回拦截模式），仅 upstream 模式、客户端策略允许 adguard 上游且配置了该上游时转发（返回 adguard 上游）；
+// 客户端组 block_domains 命中的域名始终本地拦截。
+// 未配置拦截模式且没有 adguard 上游时，与旧版本一致，命中 ads 规则的域名按普通域名分流
+func (s *Server) adsRoute(name string, policy *clientPolicy) (isAds bool, blockMode string, adguardUps []string) {
+	mode := policy.blockMode
+	modeUnset := mode == "" && strings.TrimSpace(s.cfg.Blocking.Mode) == ""
+	if mode == "" {
+		mode = s.cfg.GetBlockingMode()
+	}
+	if mode == blockModeOff {
+		return false, "", nil
+	}
+
+	configured := s.cfg.GetUpstreamGroup(UpstreamGroupAdguard)
+	groupBlock := policy.block.Match(name)
+	adsRule := policy.useRuleSet("ads") && s.match(name, "ads") && !(modeUnset && len(configured) == 0)
+	if !groupBlock && !adsRule {
+		return false, "", nil
+	}
+	if mode == BlockModeUpstream && !groupBlock && policy.allowsUpstream(UpstreamGroupAdguard) && len(configured) > 0 {
+		return true, "", configured
+	}
+	if mode == BlockModeUpstream {
+		mode = BlockModeNull
+	}
+	return true, mode, nil
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"testing"
+)
+
+func TestAdsRoute(t *testing.T) {
+	cfg := &Config{}
+	cfg.Blocking.Mode = BlockModeUpstream
+	cfg.Upstreams.Adguard = []string{"94.140.14.14:53"}
+	s := newTestServer(cfg)
+	_, ads := compileRules([]string{"ads.example"})
+	s.ruleMatchers = map[string]*domainMatcher{"ads": ads}
+
+	all := newClientPolicy(ClientGroup{Name: "all"})
+	chinaOnly := newClientPolicy(ClientGroup{Name: "kids", Upstreams: []string{UpstreamGroupChina}})
+	blocking := newClientPolicy(ClientGroup{Name: "office", BlockDomains: []string{"video.example"}})
+
+	cases := []struct {
+		name     string
+		domain   string
+		policy   *clientPolicy
+		isAds    bool
+		mode     string
+		upstream bool
+	}{
+		{"ads rule forwarded to adguard", "ads.example", all, true, "", true},
+		{"not ads", "www.example", all, false, "", false},
+		// 组的拦截列表即使在 upstream 模式下也本地拦截
+		{"group block list", "video.example", blocking, true, BlockModeNull, false},
+		{"group block list ads rule", "ads.example", blocking, true, "", true},
+		// 不允许 adguard 上游的组本地拦截，不转发到组内其他上游
+		{"adguard not allowed", "ads.example", chinaOnly, true, BlockModeNull, false},
+		{"adguard not allowed non-ads", "www.example", chinaOnly, false, "", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			isAds, mode, ups := s.adsRoute(c.domain, c.policy)
+			if isAds != c.isAds || mode != c.mode || (len(ups) > 0) != c.upstream {
+				t.Errorf("adsRoute = %v %q %v, want %v %q upstream=%v", isAds, mode, ups, c.isAds, c.mode, c.upstream)
+			}
+		})
+	}
+
+	// 组内指定的拦截模式优先
+	nx := newClientPolicy(ClientGroup{Name: "nx", BlockingMode: BlockModeNXDomain, BlockDomains: []string{"video.example"}})
+	if _, mode, _ := s.adsRoute("video.example", nx); mode != BlockModeNXDomain {
+		t.Errorf("mode = %q, want nxdomain", mode)
+	}
+
+	// 未配置拦截模式且没有 adguard 上游时，ads 规则按普通域名分流，组的拦截列表仍生效
+	legacy := newTestServer(&Config{})
+	legacy.ruleMatchers = s.ruleMatchers
+	if isAds, _, _ := legacy.adsRoute("ads.example", all); isAds {
+		t.Error("ads rule applied without blocking mode or adguard upstream")
+	}
+	if _, mode, _ := legacy.adsRoute("video.example", blocking); mode != BlockModeNull {
+		t.Errorf("group block mode = %q, want null", mode)
+	}
+}
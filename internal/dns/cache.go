@@ -0,0 +1,479 @@
+package dns
+
+import (
+	"container/list"
+	"hash/fnv"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 缓存 TTL 默认上限
+const (
+	defaultCacheMaxTTL      = 86400
+	defaultCacheNegativeTTL = 3600
+)
+
+// cacheFormatVersion 持久化缓存的格式版本，缓存键或条目格式变化时递增，加载时丢弃其他版本的数据
+const cacheFormatVersion = 2
+
+// questionKey 查询本身的缓存维度：名称（小写）、类型、类别与 DO / CD 位，格式为 name:TYPE:CLASS:DOCD
+func questionKey(req *mdns.Msg) string {
+	q := req.Question[0]
+	var b strings.Builder
+	b.WriteString(strings.ToLower(q.Name))
+	b.WriteByte(':')
+	b.WriteString(mdns.TypeToString[q.Qtype])
+	b.WriteByte(':')
+	b.WriteString(mdns.ClassToString[q.Qclass])
+	b.WriteByte(':')
+	do := false
+	if opt := req.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	b.WriteString(bit(do))
+	b.WriteString(bit(req.CheckingDisabled))
+	return b.String()
+}
+
+// requestKey 发往上游的请求的维度：questionKey 加上请求携带的 ECS 子网，格式为 name:TYPE:CLASS:DOCD:ecs
+func requestKey(req *mdns.Msg) string {
+	key := questionKey(req)
+	if opt := req.IsEdns0(); opt != nil {
+		if ecs := ecsOption(opt); ecs != nil {
+			key += ":" + ecsPrefix(ecs).String()
+		}
+	}
+	return key
+}
+
+func bit(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+// ecsOption 返回 OPT 中的 EDNS Client Subnet 选项
+func ecsOption(opt *mdns.OPT) *mdns.EDNS0_SUBNET {
+	for _, o := range opt.Option {
+		if e, ok := o.(*mdns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// ecsPrefix 返回 ECS 选项的源前缀
+func ecsPrefix(e *mdns.EDNS0_SUBNET) netip.Prefix {
+	addr, ok := netip.AddrFromSlice(e.Address)
+	if !ok {
+		return netip.Prefix{}
+	}
+	p, err := addr.Unmap().Prefix(int(e.SourceNetmask))
+	if err != nil {
+		return netip.Prefix{}
+	}
+	return p
+}
+
+// restampResponse 用当前请求改写共享或缓存的应答：消息 ID、RD / CD 标志、问题（保留客户端的大小写），
+// 客户端未携带 EDNS 时去掉 OPT 记录
+func restampResponse(resp, req *mdns.Msg) {
+	resp.Id = req.Id
+	resp.RecursionDesired = req.RecursionDesired
+	resp.CheckingDisabled = req.CheckingDisabled
+	resp.Question = append([]mdns.Question(nil), req.Question...)
+	if req.IsEdns0() == nil {
+		extra := resp.Extra[:0]
+		for _, rr := range resp.Extra {
+			if rr.Header().Rrtype != mdns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		resp.Extra = extra
+	}
+}
+
+// isNegativeResponse 判断是否为否定应答（NXDOMAIN 或 NODATA）
+func isNegativeResponse(m *mdns.Msg) bool {
+	return m.Rcode == mdns.RcodeNameError || (m.Rcode == mdns.RcodeSuccess && len(m.Answer) == 0)
+}
+
+// cacheTTL 计算应答的缓存时间，返回 0 表示不缓存：
+// 正常应答取全部 Answer 记录中最小的 TTL，并限制在 [min_ttl, max_ttl]；
+// 否定应答取权威区 SOA 的 TTL 与 minimum 中较小者 (RFC 2308)，上限为 negative_ttl，无 SOA 时不缓存；
+// SERVFAIL、REFUSED 及截断的应答不缓存
+func (s *Server) cacheTTL(m *mdns.Msg) time.Duration {
+	if m == nil || m.Truncated {
+		return 0
+	}
+	if m.Rcode != mdns.RcodeSuccess && m.Rcode != mdns.RcodeNameError {
+		return 0
+	}
+
+	var ttl uint32
+	if isNegativeResponse(m) {
+		soa := findSOA(m.Ns)
+		if soa == nil {
+			return 0
+		}
+		ttl = soa.Hdr.Ttl
+		if soa.Minttl < ttl {
+			ttl = soa.Minttl
+		}
+		if limit := s.cfg.GetCacheNegativeTTL(); ttl > limit {
+			ttl = limit
+		}
+		return time.Duration(ttl) * time.Second
+	}
+
+	ttl = m.Answer[0].Header().Ttl
+	for _, rr := range m.Answer[1:] {
+		if t := rr.Header().Ttl; t < ttl {
+			ttl = t
+		}
+	}
+	if floor := s.cfg.GetCacheMinTTL(); ttl < floor {
+		ttl = floor
+	}
+	if limit := s.cfg.GetCacheMaxTTL(); ttl > limit {
+		ttl = limit
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// findSOA 返回记录列表中的第一条 SOA
+func findSOA(rrs []mdns.RR) *mdns.SOA {
+	for _, rr := range rrs {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			return soa
+		}
+	}
+	return nil
+}
+
+// clampRecordTTLs 写入缓存前将各记录的 TTL 限制在 [floor, limit]，
+// 使记录自身的 TTL 与条目的缓存时间一致（OPT 不是真实记录，跳过）
+func clampRecordTTLs(m *mdns.Msg, floor, limit uint32) {
+	for _, section := range [][]mdns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == mdns.TypeOPT {
+				continue
+			}
+			if h.Ttl < floor {
+				h.Ttl = floor
+			}
+			if h.Ttl > limit {
+				h.Ttl = limit
+			}
+		}
+	}
+}
+
+// ageRecordTTLs 将缓存应答中每条记录的 TTL 减去已缓存的时间，各记录保持各自的剩余 TTL
+func ageRecordTTLs(m *mdns.Msg, elapsed time.Duration) {
+	age := uint32(elapsed / time.Second)
+	for _, section := range [][]mdns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == mdns.TypeOPT {
+				continue
+			}
+			if h.Ttl > age {
+				h.Ttl -= age
+			} else {
+				h.Ttl = 0
+			}
+		}
+	}
+}
+
+// setRecordTTLs 将应答中所有记录的 TTL 改写为 ttl，用于过期应答 (RFC 8767)
+func setRecordTTLs(m *mdns.Msg, ttl uint32) {
+	for _, section := range [][]mdns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == mdns.TypeOPT {
+				continue
+			}
+			rr.Header().Ttl = ttl
+		}
+	}
+}
+
+// cacheShardCount 缓存分片数，分散锁竞争
+const cacheShardCount = 32
+
+var (
+	cacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_cache_evictions_total",
+			Help: "Cache entries evicted by capacity limit or expiry",
+		},
+		[]string{"reason"},
+	)
+	cacheStaleServed = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "boomdns_cache_stale_served_total",
+			Help: "Expired cache entries served because upstreams failed or were slow (RFC 8767)",
+		},
+	)
+	cachePrefetches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_cache_prefetch_total",
+			Help: "Background refreshes of hot cache entries before expiry",
+		},
+		[]string{"result"},
+	)
+	cacheEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "boomdns_cache_entries",
+			Help: "Current number of cached responses",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(cacheEvictions, cacheStaleServed, cachePrefetches, cacheEntries)
+}
+
+// dnsCache 按 max_cache_entries 限定容量的分片 LRU 缓存
+type dnsCache struct {
+	shards   [cacheShardCount]*cacheShard
+	capacity int // 每个分片的容量
+
+	hits   atomic.Int64
+	misses atomic.Int64
+	size   atomic.Int64
+}
+
+// cacheShard 缓存分片，链表头部为最近使用的条目
+type cacheShard struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List
+}
+
+// cacheItem 链表节点保存的键值
+type cacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// newDNSCache 创建容量为 maxEntries 的缓存
+func newDNSCache(maxEntries int) *dnsCache {
+	c := &dnsCache{capacity: (maxEntries + cacheShardCount - 1) / cacheShardCount}
+	if c.capacity < 1 {
+		c.capacity = 1
+	}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{items: make(map[string]*list.Element), lru: list.New()}
+	}
+	return c
+}
+
+func (c *dnsCache) shard(key string) *cacheShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%cacheShardCount]
+}
+
+// cacheLookup 缓存查询结果
+type cacheLookup struct {
+	resp     *mdns.Msg // 应答副本
+	storedAt time.Time
+	expireAt time.Time
+	hits     int64
+	stale    bool // 已过期但仍在 serve-stale 窗口内
+}
+
+// get 查询缓存，未过期的条目计入命中次数；staleWindow 内的过期条目以 stale 标记返回
+func (c *dnsCache) get(key string, now time.Time, staleWindow time.Duration) (cacheLookup, bool) {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	el, ok := sh.items[key]
+	if !ok {
+		return cacheLookup{}, false
+	}
+	entry := el.Value.(*cacheItem).entry
+	if entry.Response == nil {
+		return cacheLookup{}, false
+	}
+	stale := now.After(entry.ExpireAt)
+	if stale && now.After(entry.ExpireAt.Add(staleWindow)) {
+		return cacheLookup{}, false
+	}
+	sh.lru.MoveToFront(el)
+	if !stale {
+		entry.Hits++
+		c.hits.Add(1)
+	}
+	return cacheLookup{resp: entry.Response.Copy(), storedAt: entry.StoredAt, expireAt: entry.ExpireAt, hits: entry.Hits, stale: stale}, true
+}
+
+// set 写入条目，分片超出容量时淘汰最久未使用的条目；entry 写入后由缓存持有
+func (c *dnsCache) set(key string, entry *CacheEntry) {
+	sh := c.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if el, ok := sh.items[key]; ok {
+		// 刷新条目时保留命中次数，热点条目可持续预取
+		item := el.Value.(*cacheItem)
+		entry.Hits = item.entry.Hits
+		item.entry = entry
+		sh.lru.MoveToFront(el)
+		return
+	}
+	sh.items[key] = sh.lru.PushFront(&cacheItem{key: key, entry: entry})
+	c.size.Add(1)
+	for sh.lru.Len() > c.capacity {
+		sh.removeLocked(c, sh.lru.Back())
+		cacheEvictions.WithLabelValues("capacity").Inc()
+	}
+	cacheEntries.Set(float64(c.size.Load()))
+}
+
+// removeLocked 删除链表节点，调用方需持有分片锁
+func (sh *cacheShard) removeLocked(c *dnsCache, el *list.Element) {
+	sh.lru.Remove(el)
+	delete(sh.items, el.Value.(*cacheItem).key)
+	c.size.Add(-1)
+}
+
+// removeExpired 清理在 cutoff 之前过期的条目，返回清理数量
+func (c *dnsCache) removeExpired(cutoff time.Time) int {
+	removed := 0
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for el := sh.lru.Back(); el != nil; {
+			prev := el.Prev()
+			if cutoff.After(el.Value.(*cacheItem).entry.ExpireAt) {
+				sh.removeLocked(c, el)
+				removed++
+			}
+			el = prev
+		}
+		sh.mu.Unlock()
+	}
+	cacheEvictions.WithLabelValues("expired").Add(float64(removed))
+	cacheEntries.Set(float64(c.size.Load()))
+	return removed
+}
+
+// each 遍历所有条目（条目只读），fn 返回 false 时停止
+func (c *dnsCache) each(fn func(key string, entry *CacheEntry) bool) {
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		for el := sh.lru.Front(); el != nil; el = el.Next() {
+			item := el.Value.(*cacheItem)
+			if !fn(item.key, item.entry) {
+				sh.mu.Unlock()
+				return
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// snapshot 复制全部条目，用于持久化
+func (c *dnsCache) snapshot() map[string]*CacheEntry {
+	out := make(map[string]*CacheEntry, c.size.Load())
+	c.each(func(key string, entry *CacheEntry) bool {
+		e := *entry
+		out[key] = &e
+		return true
+	})
+	return out
+}
+
+// load 导入持久化的条目，超出容量的部分按 LRU 淘汰；
+// 缺少写入时间的旧条目无法计算记录的剩余 TTL，直接丢弃
+func (c *dnsCache) load(entries map[string]*CacheEntry) {
+	for key, entry := range entries {
+		if entry != nil && entry.Response != nil && !entry.StoredAt.IsZero() {
+			c.set(key, entry)
+		}
+	}
+}
+
+// clear 清空缓存及命中统计
+func (c *dnsCache) clear() {
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		sh.items = make(map[string]*list.Element)
+		sh.lru.Init()
+		sh.mu.Unlock()
+	}
+	c.size.Store(0)
+	c.hits.Store(0)
+	c.misses.Store(0)
+	cacheEntries.Set(0)
+}
+
+// serveFromCache 命中未过期缓存时直接应答，命中次数达到阈值的条目临近过期时在后台预取；
+// 未命中时返回 serve-stale 窗口内的过期应答（没有则为 nil）
+func (s *Server) serveFromCache(w mdns.ResponseWriter, r *mdns.Msg, policy *clientPolicy, key string, resolve func() resolution) (bool, *mdns.Msg) {
+	l, ok := s.cache.get(key, time.Now(), s.cfg.GetStaleWindow())
+	if ok {
+		restampResponse(l.resp, r)
+		matchECSEcho(l.resp, r)
+	}
+	if ok && !l.stale {
+		remaining := time.Until(l.expireAt)
+		ageRecordTTLs(l.resp, time.Since(l.storedAt))
+		entry := newQueryLog(w, r, l.resp, policy, "cache", 0) // 缓存命中，延迟为0
+		entry.Cached = true
+		s.addLog(entry)
+		queryCounter.WithLabelValues("cache").Inc()
+		_ = w.WriteMsg(l.resp)
+
+		if s.cfg.Cache.Prefetch && l.hits >= int64(s.cfg.GetPrefetchHits()) && remaining <= s.cfg.GetPrefetchWindow() {
+			s.prefetch(key, resolve)
+		}
+		return true, nil
+	}
+
+	// 缓存未命中，记录统计
+	s.cache.misses.Add(1)
+	if ok {
+		return false, l.resp
+	}
+	return false, nil
+}
+
+// writeStale 返回过期应答，TTL 改写为 stale_ttl
+func (s *Server) writeStale(w mdns.ResponseWriter, r *mdns.Msg, policy *clientPolicy, stale *mdns.Msg) {
+	setRecordTTLs(stale, uint32(s.cfg.GetStaleTTL()))
+	entry := newQueryLog(w, r, stale, policy, "stale", 0)
+	entry.Cached = true
+	s.addLog(entry)
+	queryCounter.WithLabelValues("stale").Inc()
+	cacheStaleServed.Inc()
+	_ = w.WriteMsg(stale)
+}
+
+// prefetch 在后台刷新缓存条目，同一条目同时只有一个预取
+func (s *Server) prefetch(key string, resolve func() resolution) {
+	if _, running := s.prefetching.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer s.prefetching.Delete(key)
+		res := resolve()
+		if res.err != nil {
+			cachePrefetches.WithLabelValues("failure").Inc()
+			return
+		}
+		s.recordResolution(key, res)
+		cachePrefetches.WithLabelValues("success").Inc()
+	}()
+}
@@ -0,0 +1,255 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+func newCacheServer(configure func(cfg *Config)) *Server {
+	cfg := &Config{}
+	cfg.Cache.MinTTL = 60
+	cfg.Cache.MaxTTL = 300
+	cfg.Cache.NegativeTTL = 600
+	if configure != nil {
+		configure(cfg)
+	}
+	return newTestServer(cfg)
+}
+
+// cacheReply 构造 www.example.com. 的应答，每个 TTL 对应一条 A 记录
+func cacheReply(t *testing.T, req *mdns.Msg, ttls ...uint32) *mdns.Msg {
+	t.Helper()
+	m := new(mdns.Msg)
+	m.SetReply(req)
+	for i, ttl := range ttls {
+		m.Answer = append(m.Answer, &mdns.A{
+			Hdr: mdns.RR_Header{Name: req.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: ttl},
+			A:   net.IPv4(192, 0, 2, byte(i+1)),
+		})
+	}
+	return m
+}
+
+func answerTTLs(m *mdns.Msg) []uint32 {
+	var out []uint32
+	for _, rr := range m.Answer {
+		out = append(out, rr.Header().Ttl)
+	}
+	return out
+}
+
+func equalTTLs(got []uint32, want ...uint32) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCacheTTL(t *testing.T) {
+	s := newCacheServer(nil)
+	req := testQuery("www.example.com.")
+
+	negative := func(rcode int, soaTTL, minTTL uint32) *mdns.Msg {
+		m := new(mdns.Msg)
+		m.SetRcode(req, rcode)
+		m.Ns = append(m.Ns, &mdns.SOA{
+			Hdr: mdns.RR_Header{Name: "example.com.", Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: soaTTL},
+			Ns:  "ns.example.com.", Mbox: "admin.example.com.", Minttl: minTTL,
+		})
+		return m
+	}
+	servfail := new(mdns.Msg)
+	servfail.SetRcode(req, mdns.RcodeServerFailure)
+	truncated := cacheReply(t, req, 100)
+	truncated.Truncated = true
+
+	cases := []struct {
+		name string
+		resp *mdns.Msg
+		want time.Duration
+	}{
+		{"minimum answer ttl", cacheReply(t, req, 200, 120), 120 * time.Second},
+		{"min_ttl floor", cacheReply(t, req, 10, 200), 60 * time.Second},
+		{"max_ttl cap", cacheReply(t, req, 1000), 300 * time.Second},
+		// RFC 2308：SOA TTL 与 minimum 中较小者，上限 negative_ttl，不受 min_ttl 影响
+		{"nxdomain soa minimum", negative(mdns.RcodeNameError, 3600, 30), 30 * time.Second},
+		{"nodata negative_ttl cap", negative(mdns.RcodeSuccess, 7200, 3600), 600 * time.Second},
+		{"negative without soa", func() *mdns.Msg { m := new(mdns.Msg); m.SetRcode(req, mdns.RcodeNameError); return m }(), 0},
+		{"servfail", servfail, 0},
+		{"truncated", truncated, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := s.cacheTTL(c.resp); got != c.want {
+				t.Errorf("cacheTTL = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCacheRecordTTLs(t *testing.T) {
+	s := newCacheServer(nil)
+	req := testQuery("www.example.com.")
+	key := "test"
+
+	// 写入时各记录的 TTL 限制在 [min_ttl, max_ttl]
+	s.setCache(key, cacheReply(t, req, 10, 120, 1000))
+	l, ok := s.cache.get(key, time.Now(), 0)
+	if !ok {
+		t.Fatal("entry not cached")
+	}
+	if got := answerTTLs(l.resp); !equalTTLs(got, 60, 120, 300) {
+		t.Errorf("stored ttls = %v, want [60 120 300]", got)
+	}
+
+	// 命中时每条记录按已缓存的时间递减各自的 TTL
+	s.cache.set(key, &CacheEntry{
+		Response: cacheReply(t, req, 60, 120, 300),
+		StoredAt: time.Now().Add(-30 * time.Second),
+		ExpireAt: time.Now().Add(30 * time.Second),
+	})
+	w := &recordWriter{remote: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 100)}}
+	served, _ := s.serveFromCache(w, req, defaultPolicy, key, nil)
+	if !served || len(w.msgs) != 1 {
+		t.Fatalf("served = %v, msgs = %d", served, len(w.msgs))
+	}
+	if got := answerTTLs(w.msgs[0]); !equalTTLs(got, 30, 90, 270) {
+		t.Errorf("served ttls = %v, want [30 90 270]", got)
+	}
+	if w.msgs[0].Id != req.Id {
+		t.Errorf("id = %d, want %d", w.msgs[0].Id, req.Id)
+	}
+}
+
+// storeExpired 写入已过期 expiredFor 的缓存条目
+func storeExpired(t *testing.T, s *Server, key string, req *mdns.Msg, expiredFor time.Duration) {
+	t.Helper()
+	now := time.Now()
+	s.cache.set(key, &CacheEntry{
+		Response: cacheReply(t, req, 60),
+		StoredAt: now.Add(-expiredFor - time.Minute),
+		ExpireAt: now.Add(-expiredFor),
+	})
+}
+
+func TestServeStale(t *testing.T) {
+	s := newCacheServer(func(cfg *Config) {
+		cfg.Cache.ServeStale = true
+		cfg.Cache.StaleWindow = 3600
+		cfg.Cache.StaleTTL = 15
+		cfg.Cache.StaleAnswerTimeoutMs = 50
+	})
+	req := testQuery("www.example.com.")
+	w := &recordWriter{remote: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 100)}}
+
+	// 上游失败时返回过期应答，TTL 为 stale_ttl
+	storeExpired(t, s, "failed", req, 10*time.Second)
+	served, stale := s.serveFromCache(w, req, defaultPolicy, "failed", nil)
+	if served || stale == nil {
+		t.Fatalf("served = %v, stale = %v, want a stale answer", served, stale)
+	}
+	s.serveResolved(w, req, defaultPolicy, "failed", stale, func() resolution {
+		return resolution{route: "intl", err: errors.New("upstream down")}
+	})
+	if len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+		t.Fatalf("msgs = %v, want the stale answer", w.msgs)
+	}
+	if got := answerTTLs(w.msgs[0]); !equalTTLs(got, 15) {
+		t.Errorf("stale ttls = %v, want [15]", got)
+	}
+
+	// 上游超过 stale_answer_timeout 未应答时先返回过期应答，后台解析完成后刷新缓存
+	storeExpired(t, s, "slow", req, 10*time.Second)
+	_, stale = s.serveFromCache(w, req, defaultPolicy, "slow", nil)
+	release := make(chan struct{})
+	start := time.Now()
+	s.serveResolved(w, req, defaultPolicy, "slow", stale, func() resolution {
+		<-release
+		return resolution{resp: cacheReply(t, req, 120), route: "intl"}
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stale answer took %v", elapsed)
+	}
+	if len(w.msgs) != 2 || !equalTTLs(answerTTLs(w.msgs[1]), 15) {
+		t.Fatalf("msgs = %v, want a second stale answer", w.msgs)
+	}
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if l, ok := s.cache.get("slow", time.Now(), 0); ok && !l.stale {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("cache not refreshed by background resolution")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 超过 stale_window 的条目不再返回
+	storeExpired(t, s, "old", req, 2*time.Hour)
+	if _, stale := s.serveFromCache(w, req, defaultPolicy, "old", nil); stale != nil {
+		t.Error("entry beyond stale_window returned")
+	}
+
+	// 未启用 serve-stale 时过期条目不返回
+	off := newCacheServer(nil)
+	storeExpired(t, off, "failed", req, 10*time.Second)
+	if _, stale := off.serveFromCache(w, req, defaultPolicy, "failed", nil); stale != nil {
+		t.Error("stale answer returned with serve_stale disabled")
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	s := newCacheServer(func(cfg *Config) {
+		cfg.Cache.Prefetch = true
+		cfg.Cache.PrefetchHits = 2
+		cfg.Cache.PrefetchWindow = 10
+	})
+	req := testQuery("www.example.com.")
+	w := &recordWriter{remote: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 100)}}
+
+	var resolves atomic.Int64
+	resolve := func() resolution {
+		resolves.Add(1)
+		return resolution{resp: cacheReply(t, req, 200), route: "intl"}
+	}
+	now := time.Now()
+	s.cache.set("hot", &CacheEntry{Response: cacheReply(t, req, 60), StoredAt: now.Add(-55 * time.Second), ExpireAt: now.Add(5 * time.Second)})
+	s.cache.set("cold", &CacheEntry{Response: cacheReply(t, req, 60), StoredAt: now, ExpireAt: now.Add(60 * time.Second)})
+
+	// 未达到命中次数或不在预取窗口内时不预取
+	s.serveFromCache(w, req, defaultPolicy, "hot", resolve)
+	for i := 0; i < 3; i++ {
+		s.serveFromCache(w, req, defaultPolicy, "cold", resolve)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := resolves.Load(); n != 0 {
+		t.Fatalf("prefetched %d times, want 0", n)
+	}
+
+	// 热点条目临近过期时在后台刷新
+	s.serveFromCache(w, req, defaultPolicy, "hot", resolve)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if l, ok := s.cache.get("hot", time.Now(), 0); ok && time.Until(l.expireAt) > time.Minute {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hot entry not refreshed by prefetch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := resolves.Load(); n != 1 {
+		t.Errorf("prefetched %d times, want 1", n)
+	}
+}
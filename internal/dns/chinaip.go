@@ -0,0 +1,236 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ipRange 闭区间 IP 段
+type ipRange struct {
+	start netip.Addr
+	end   netip.Addr
+}
+
+// ipRangeSet 有序、已合并的 IP 段集合，支持二分查找
+type ipRangeSet struct {
+	ranges []ipRange
+}
+
+// newIPRangeSet 由 CIDR 列表构建集合，重叠或相邻的网段会被合并
+func newIPRangeSet(prefixes []netip.Prefix) *ipRangeSet {
+	ranges := make([]ipRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		p = p.Masked()
+		ranges = append(ranges, ipRange{start: p.Addr(), end: prefixLastAddr(p)})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start.Less(ranges[j].start) })
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.start.BitLen() == merged[n-1].end.BitLen() {
+			last := &merged[n-1]
+			if next := last.end.Next(); !next.IsValid() || !next.Less(r.start) {
+				if last.end.Less(r.end) {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+	return &ipRangeSet{ranges: merged}
+}
+
+// Contains 判断 IP 是否落在集合中
+func (s *ipRangeSet) Contains(ip netip.Addr) bool {
+	if s == nil {
+		return false
+	}
+	ip = ip.Unmap()
+	// 找到第一个起始地址大于 ip 的区间，候选为其前一个
+	i := sort.Search(len(s.ranges), func(i int) bool { return ip.Less(s.ranges[i].start) })
+	if i == 0 {
+		return false
+	}
+	r := s.ranges[i-1]
+	return r.start.BitLen() == ip.BitLen() && !r.end.Less(ip)
+}
+
+// Len 返回合并后的网段数量
+func (s *ipRangeSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.ranges)
+}
+
+// prefixLastAddr 计算网段中的最后一个地址
+func prefixLastAddr(p netip.Prefix) netip.Addr {
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		for i := p.Bits(); i < 32; i++ {
+			b[i/8] |= 1 << (7 - uint(i%8))
+		}
+		return netip.AddrFrom4(b)
+	}
+	b := p.Addr().As16()
+	for i := p.Bits(); i < 128; i++ {
+		b[i/8] |= 1 << (7 - uint(i%8))
+	}
+	return netip.AddrFrom16(b)
+}
+
+// parseCIDRList 解析每行一个 CIDR（或单个 IP）的列表，忽略注释与空行
+func parseCIDRList(r io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if p, err := netip.ParsePrefix(line); err == nil {
+			prefixes = append(prefixes, p)
+			continue
+		}
+		if ip, err := netip.ParseAddr(line); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+		}
+	}
+	return prefixes, scanner.Err()
+}
+
+// ChinaIPManager 中国 IP 段管理器，从本地文件加载并可定期从订阅地址更新
+type ChinaIPManager struct {
+	cfg    *Config
+	client *http.Client
+	set    atomic.Pointer[ipRangeSet]
+}
+
+// NewChinaIPManager 创建中国 IP 段管理器并加载本地列表
+func NewChinaIPManager(cfg *Config) *ChinaIPManager {
+	m := &ChinaIPManager{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.GetSubscriptionsTimeout()},
+	}
+	if err := m.loadFile(cfg.GetChinaIPFile()); err != nil {
+		log.Printf("加载中国 IP 列表失败: %v", err)
+	}
+	return m
+}
+
+// Start 启动订阅更新（未配置 URL 时直接返回）
+func (m *ChinaIPManager) Start() {
+	if m.cfg.ChinaIP.URL == "" {
+		return
+	}
+	m.update()
+
+	ticker := time.NewTicker(m.cfg.GetChinaIPUpdateInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		m.update()
+	}
+}
+
+// Contains 判断 IP 是否属于中国 IP 段
+func (m *ChinaIPManager) Contains(ip netip.Addr) bool {
+	return m.set.Load().Contains(ip)
+}
+
+// Loaded 是否已加载到可用的 IP 段
+func (m *ChinaIPManager) Loaded() bool {
+	return m.set.Load().Len() > 0
+}
+
+// loadFile 从本地文件加载 IP 段
+func (m *ChinaIPManager) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	prefixes, err := parseCIDRList(f)
+	if err != nil {
+		return err
+	}
+	set := newIPRangeSet(prefixes)
+	m.set.Store(set)
+	log.Printf("中国 IP 列表已加载: %d 条网段 (合并后 %d)", len(prefixes), set.Len())
+	return nil
+}
+
+// update 下载订阅列表，写入本地文件后重新加载
+func (m *ChinaIPManager) update() {
+	req, err := http.NewRequest(http.MethodGet, m.cfg.ChinaIP.URL, nil)
+	if err != nil {
+		log.Printf("更新中国 IP 列表失败: %v", err)
+		return
+	}
+	req.Header.Set("User-Agent", m.cfg.GetSubscriptionsUserAgent())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.Printf("更新中国 IP 列表失败: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("更新中国 IP 列表失败: HTTP %d", resp.StatusCode)
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("读取中国 IP 列表失败: %v", err)
+		return
+	}
+	prefixes, err := parseCIDRList(strings.NewReader(string(body)))
+	if err != nil || len(prefixes) == 0 {
+		log.Printf("中国 IP 列表内容无效: %v", err)
+		return
+	}
+
+	path := m.cfg.GetChinaIPFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		if err := os.WriteFile(path, body, 0644); err != nil {
+			log.Printf("保存中国 IP 列表失败: %v", err)
+		}
+	}
+
+	set := newIPRangeSet(prefixes)
+	m.set.Store(set)
+	log.Printf("中国 IP 列表已更新: %d 条网段 (合并后 %d)", len(prefixes), set.Len())
+}
+
+// IsChinaAnswer 判断响应中的 A/AAAA 记录是否全部位于中国 IP 段
+// 响应中没有地址记录时视为通过
+func (m *ChinaIPManager) IsChinaAnswer(resp *mdns.Msg) bool {
+	for _, rr := range resp.Answer {
+		var ip netip.Addr
+		switch v := rr.(type) {
+		case *mdns.A:
+			ip, _ = netip.AddrFromSlice(v.A.To4())
+		case *mdns.AAAA:
+			ip, _ = netip.AddrFromSlice(v.AAAA)
+		default:
+			continue
+		}
+		if !m.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,384 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 上游组名称
+const (
+	UpstreamGroupChina   = "china"
+	UpstreamGroupIntl    = "intl"
+	UpstreamGroupAdguard = "adguard"
+)
+
+// blockModeOff 客户端组关闭广告拦截
+const blockModeOff = "off"
+
+// ClientGroup 客户端组策略，按客户端 IP / CIDR 归组
+type ClientGroup struct {
+	Name    string   `json:"name" yaml:"name"`
+	Clients []string `json:"clients" yaml:"clients"` // IP 或 CIDR
+
+	// 生效的规则分类（china / gfw / ads），为空表示全部
+	RuleSets []string `json:"rule_sets" yaml:"rule_sets"`
+	// 额外拦截的域名规则（支持完整规则语法）
+	BlockDomains []string `json:"block_domains" yaml:"block_domains"`
+	// 允许使用的上游组（china / intl / adguard），为空表示全部；
+	// 路由选中的上游组不被允许时，改用列表中的第一个
+	Upstreams []string `json:"upstreams" yaml:"upstreams"`
+	// 拦截模式，为空时使用全局配置，off 表示不拦截
+	BlockingMode string `json:"blocking_mode" yaml:"blocking_mode"`
+}
+
+// Normalize 标准化并校验客户端组
+func (g *ClientGroup) Normalize() error {
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		return fmt.Errorf("客户端组名称不能为空")
+	}
+	if len(g.Clients) == 0 {
+		return fmt.Errorf("客户端组 %s 未配置客户端", g.Name)
+	}
+	for i, c := range g.Clients {
+		p, err := parseClientPrefix(c)
+		if err != nil {
+			return err
+		}
+		g.Clients[i] = p.String()
+	}
+	for i, rs := range g.RuleSets {
+		rs = strings.ToLower(strings.TrimSpace(rs))
+		if rs != "china" && rs != "gfw" && rs != "ads" {
+			return fmt.Errorf("未知的规则分类: %s", rs)
+		}
+		g.RuleSets[i] = rs
+	}
+	for i, u := range g.Upstreams {
+		u = strings.ToLower(strings.TrimSpace(u))
+		if u != UpstreamGroupChina && u != UpstreamGroupIntl && u != UpstreamGroupAdguard {
+			return fmt.Errorf("未知的上游组: %s", u)
+		}
+		g.Upstreams[i] = u
+	}
+	for _, d := range g.BlockDomains {
+		if _, err := ParseDomainRule(d); err != nil {
+			return err
+		}
+	}
+	g.BlockingMode = strings.ToLower(strings.TrimSpace(g.BlockingMode))
+	if g.BlockingMode != "" && g.BlockingMode != blockModeOff && !IsValidBlockMode(g.BlockingMode) {
+		return fmt.Errorf("未知的拦截模式: %s", g.BlockingMode)
+	}
+	return nil
+}
+
+// parseClientPrefix 解析 IP 或 CIDR
+func parseClientPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), nil
+	}
+	if ip, err := netip.ParseAddr(s); err == nil {
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), nil
+	}
+	return netip.Prefix{}, fmt.Errorf("无效的客户端地址: %s", s)
+}
+
+// clientPolicy 编译后的客户端策略
+type clientPolicy struct {
+	name      string
+	key       string          // 策略内容的标识，用于缓存键与合并请求键，内容相同的组共享缓存
+	ruleSets  map[string]bool // nil 表示全部
+	upstreams []string        // nil 表示全部
+	blockMode string          // 空表示使用全局配置
+	block     *domainMatcher
+}
+
+// defaultPolicy 未命中任何客户端组时使用的策略
+var defaultPolicy = newClientPolicy(ClientGroup{Name: "default"})
+
+// newClientPolicy 编译客户端组策略
+func newClientPolicy(g ClientGroup) *clientPolicy {
+	p := &clientPolicy{name: g.Name, blockMode: g.BlockingMode}
+	if len(g.RuleSets) > 0 {
+		p.ruleSets = make(map[string]bool, len(g.RuleSets))
+		for _, rs := range g.RuleSets {
+			p.ruleSets[rs] = true
+		}
+	}
+	if len(g.Upstreams) > 0 {
+		p.upstreams = append([]string(nil), g.Upstreams...)
+	}
+	if len(g.BlockDomains) > 0 {
+		_, p.block = compileRules(g.BlockDomains)
+	}
+	p.key = policyKey(g)
+	return p
+}
+
+// policyKey 由影响解析结果的字段（规则分类、上游组、拦截模式与拦截规则）生成策略标识，
+// 格式为 rs=china,gfw;up=intl;bm=null;bl=<规则哈希>，未限制的字段为 *
+func policyKey(g ClientGroup) string {
+	list := func(v []string, sorted bool) string {
+		if len(v) == 0 {
+			return "*"
+		}
+		v = append([]string(nil), v...)
+		if sorted {
+			sort.Strings(v)
+		}
+		return strings.Join(v, ",")
+	}
+	key := "rs=" + list(g.RuleSets, true) + ";up=" + list(g.Upstreams, false) + ";bm=" + g.BlockingMode
+	if len(g.BlockDomains) > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(list(g.BlockDomains, true)))
+		key += fmt.Sprintf(";bl=%x", h.Sum64())
+	}
+	return key
+}
+
+// useRuleSet 判断规则分类是否对该客户端生效
+func (p *clientPolicy) useRuleSet(category string) bool {
+	return p.ruleSets == nil || p.ruleSets[category]
+}
+
+// allowsUpstream 判断该客户端是否允许使用上游组
+func (p *clientPolicy) allowsUpstream(group string) bool {
+	return p.upstreams == nil || slices.Contains(p.upstreams, group)
+}
+
+// upstreamGroup 返回该客户端实际使用的上游组
+func (p *clientPolicy) upstreamGroup(group string) string {
+	if p.allowsUpstream(group) {
+		return group
+	}
+	return p.upstreams[0]
+}
+
+// clientPrefixPolicy 前缀到策略的映射
+type clientPrefixPolicy struct {
+	prefix netip.Prefix
+	policy *clientPolicy
+}
+
+// ErrConfigClientGroup 配置文件中定义的客户端组不能通过 API 删除
+var ErrConfigClientGroup = errors.New("客户端组定义在配置文件中，请修改配置文件")
+
+// ClientPolicyManager 客户端组策略管理器，变更实时生效
+type ClientPolicyManager struct {
+	store *SQLiteManager // 为 nil 时 API 修改仅保存在内存
+
+	mu         sync.Mutex
+	groups     []ClientGroup
+	config     map[string]ClientGroup // 配置文件中定义的组
+	overridden map[string]bool        // 通过 API 保存、覆盖了配置定义的组
+
+	// 按前缀长度降序排列，保证最长前缀优先
+	compiled  atomic.Pointer[[]clientPrefixPolicy]
+	rebuildMu sync.Mutex // 串行化复制、编译与发布，并发修改时最后发布的总是最新的组
+}
+
+// NewClientPolicyManager 创建客户端策略管理器，SQLite 中保存的组覆盖配置文件中的同名组
+func NewClientPolicyManager(cfg *Config, storage StorageManager) *ClientPolicyManager {
+	m := &ClientPolicyManager{
+		config:     make(map[string]ClientGroup),
+		overridden: make(map[string]bool),
+	}
+	byName := make(map[string]ClientGroup)
+	var order []string
+	add := func(g ClientGroup) bool {
+		if err := g.Normalize(); err != nil {
+			log.Printf("忽略无效的客户端组: %v", err)
+			return false
+		}
+		if _, ok := byName[g.Name]; !ok {
+			order = append(order, g.Name)
+		}
+		byName[g.Name] = g
+		return true
+	}
+	for _, g := range cfg.ClientGroups {
+		if add(g) {
+			m.config[g.Name] = byName[g.Name]
+		}
+	}
+	if sm, ok := storage.(*SQLiteManager); ok {
+		m.store = sm
+		groups, err := sm.GetClientGroups()
+		if err != nil {
+			log.Printf("加载客户端组失败: %v", err)
+		}
+		for _, g := range groups {
+			if add(g) {
+				if _, ok := m.config[g.Name]; ok {
+					m.overridden[g.Name] = true
+				}
+			}
+		}
+	}
+	for _, name := range order {
+		m.groups = append(m.groups, byName[name])
+	}
+	m.rebuild()
+	return m
+}
+
+// Lookup 返回客户端地址对应的策略
+func (m *ClientPolicyManager) Lookup(addr net.Addr) *clientPolicy {
+	if m == nil || addr == nil {
+		return defaultPolicy
+	}
+	list := m.compiled.Load()
+	if list == nil || len(*list) == 0 {
+		return defaultPolicy
+	}
+	ip, ok := addrIP(addr)
+	if !ok {
+		return defaultPolicy
+	}
+	for _, pp := range *list {
+		if pp.prefix.Contains(ip) {
+			return pp.policy
+		}
+	}
+	return defaultPolicy
+}
+
+// List 列出所有客户端组
+func (m *ClientPolicyManager) List() []ClientGroup {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]ClientGroup, len(m.groups))
+	copy(out, m.groups)
+	return out
+}
+
+// Save 新增或替换同名客户端组
+func (m *ClientPolicyManager) Save(g ClientGroup) error {
+	if err := g.Normalize(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if m.store != nil {
+		if err := m.store.SaveClientGroup(g); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	replaced := false
+	for i := range m.groups {
+		if m.groups[i].Name == g.Name {
+			m.groups[i] = g
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		m.groups = append(m.groups, g)
+	}
+	if _, ok := m.config[g.Name]; ok {
+		m.overridden[g.Name] = true
+	}
+	m.mu.Unlock()
+
+	m.rebuild()
+	return nil
+}
+
+// Delete 删除客户端组。配置文件中定义的组不能删除（重启后会重新加载）：
+// 存在 API 保存的覆盖时删除覆盖并恢复配置中的定义，否则返回 ErrConfigClientGroup
+func (m *ClientPolicyManager) Delete(name string) error {
+	m.mu.Lock()
+	idx := -1
+	for i := range m.groups {
+		if m.groups[i].Name == name {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("客户端组不存在: %s", name)
+	}
+	cfgGroup, fromConfig := m.config[name]
+	if fromConfig && !m.overridden[name] {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrConfigClientGroup, name)
+	}
+	if m.store != nil {
+		if err := m.store.DeleteClientGroup(name); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	if fromConfig {
+		m.groups[idx] = cfgGroup
+		delete(m.overridden, name)
+	} else {
+		m.groups = append(m.groups[:idx], m.groups[idx+1:]...)
+	}
+	m.mu.Unlock()
+
+	m.rebuild()
+	return nil
+}
+
+// rebuild 编译所有客户端组
+func (m *ClientPolicyManager) rebuild() {
+	m.rebuildMu.Lock()
+	defer m.rebuildMu.Unlock()
+
+	m.mu.Lock()
+	groups := make([]ClientGroup, len(m.groups))
+	copy(groups, m.groups)
+	m.mu.Unlock()
+
+	var list []clientPrefixPolicy
+	for _, g := range groups {
+		p := newClientPolicy(g)
+		for _, c := range g.Clients {
+			prefix, err := parseClientPrefix(c)
+			if err != nil {
+				continue
+			}
+			list = append(list, clientPrefixPolicy{prefix: prefix, policy: p})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].prefix.Bits() > list[j].prefix.Bits() })
+	m.compiled.Store(&list)
+}
+
+// addrIP 从 net.Addr 中提取 IP
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		ip = net.ParseIP(host)
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return a.Unmap(), true
+}
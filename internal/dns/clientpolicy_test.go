@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestClientPolicyConcurrentSave(t *testing.T) {
+	m := NewClientPolicyManager(&Config{}, nil)
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := ClientGroup{Name: fmt.Sprintf("g%d", i), Clients: []string{fmt.Sprintf("10.0.%d.0/24", i)}}
+			if err := m.Save(g); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 每个已确认的修改都必须体现在最终发布的策略中
+	for i := 0; i < n; i++ {
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i), 1)}
+		if got := m.Lookup(addr).name; got != fmt.Sprintf("g%d", i) {
+			t.Errorf("Lookup(%s) = %s, want g%d", addr.IP, got, i)
+		}
+	}
+}
+
+func TestLocalRecordConcurrentAdd(t *testing.T) {
+	m := NewLocalRecordManager(&Config{}, nil)
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := LocalRecord{Name: fmt.Sprintf("host%d.lan", i), Type: "A", Value: fmt.Sprintf("10.0.0.%d", i+1), TTL: 60}
+			if _, err := m.Add(rec); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("host%d.lan", i)
+		if answers, found := m.Lookup(name, mdns.TypeA, mdns.ClassINET); !found || len(answers) != 1 {
+			t.Errorf("Lookup(%s) = %v %v, want one A record", name, answers, found)
+		}
+	}
+}
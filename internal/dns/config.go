@@ -1,7 +1,11 @@
 package dns
 
 import (
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/winspan/boomdns/pkg/config"
 )
 
 // SubscriptionRuleSource 订阅规则源配置
@@ -14,17 +18,48 @@ type SubscriptionRuleSource struct {
 
 // 注意：ProxyNode、ProxyGroup、ProxyRule 类型已在 dns/proxy.go 中定义
 
+// UpstreamGroupOptions 上游组的选择策略与超时
+type UpstreamGroupOptions struct {
+	Strategy  string `yaml:"strategy"`   // sequential（默认）/ parallel / fastest / round-robin
+	TimeoutMs int    `yaml:"timeout_ms"` // 单次尝试超时（毫秒），默认 3000
+
+	// 熔断：连续失败 fail_threshold 次后熔断 open_seconds 秒，到期后发送探测查询，成功才恢复
+	FailThreshold int `yaml:"fail_threshold"` // 默认 3
+	OpenSeconds   int `yaml:"open_seconds"`   // 默认 30
+}
+
 // Config DNS服务器配置
 type Config struct {
 	ListenDNS  string `yaml:"listen_dns"`
 	ListenHTTP string `yaml:"listen_http"`
 	AdminToken string `yaml:"admin_token"`
 
+	// 加密 DNS 监听（DoH / DoT / DoQ），共用同一对证书
+	ListenDoH string `yaml:"listen_doh"`
+	ListenDoT string `yaml:"listen_dot"`
+	ListenDoQ string `yaml:"listen_doq"`
+	DoHPath   string `yaml:"doh_path"`
+	TLSCert   string `yaml:"tls_cert"`
+	TLSKey    string `yaml:"tls_key"`
+
 	// 上游DNS服务器配置
 	Upstreams struct {
 		China   []string `yaml:"china"`
 		Intl    []string `yaml:"intl"`
 		Adguard []string `yaml:"adguard"`
+
+		// 按上游组（china / intl / adguard / recursive）配置选择策略与超时
+		Options map[string]UpstreamGroupOptions `yaml:"options"`
+
+		// 内置递归解析：从根提示开始迭代查询，不依赖公共解析器。
+		// 任意上游列表中写 "recursive" 即可使用，categories 中的分类直接走递归解析
+		Recursive struct {
+			Enabled                  bool     `yaml:"enabled"`
+			RootHints                []string `yaml:"root_hints"`                 // 根服务器地址，默认 IANA 根提示
+			Port                     int      `yaml:"port"`                       // 权威服务器端口，默认 53
+			Categories               []string `yaml:"categories"`                 // 直接走递归解析的分类：china / gfw / others
+			DisableQnameMinimisation bool     `yaml:"disable_qname_minimisation"` // 关闭 QNAME 最小化 (RFC 9156)
+		} `yaml:"recursive"`
 	} `yaml:"upstreams"`
 
 	// 域名规则配置
@@ -34,6 +69,70 @@ type Config struct {
 		Ads   []string `yaml:"ads"`
 	} `yaml:"domains"`
 
+	// 广告拦截：命中 ads 规则时在本地合成应答
+	Blocking struct {
+		Mode string `yaml:"mode"` // nxdomain, refused, nodata, null, custom_ip, upstream
+		IPv4 string `yaml:"ipv4"` // custom_ip 模式下 A 记录返回的地址
+		IPv6 string `yaml:"ipv6"` // custom_ip 模式下 AAAA 记录返回的地址
+		TTL  uint32 `yaml:"ttl"`
+	} `yaml:"blocking"`
+
+	// 安全配置：客户端限速与响应限速 (RRL)，与 pkg/config 共用同一结构
+	Security config.Security `yaml:"security"`
+
+	// 客户端组策略（按客户端 IP / CIDR 选择规则分类、上游组与拦截模式）
+	ClientGroups []ClientGroup `yaml:"client_groups"`
+
+	// EDNS Client Subnet 策略，按路由（china / intl / adguard / zone:<区域>）配置，未配置时原样转发
+	ECS map[string]ECSPolicy `yaml:"ecs"`
+
+	// DNSSEC 校验：启用后对指定路由的应答设置 DO 位，从信任锚逐级验证签名，
+	// 通过时设置 AD 位，校验失败（bogus）时返回 SERVFAIL 或仅记录日志
+	DNSSEC struct {
+		Enabled      bool     `yaml:"enabled"`
+		Mode         string   `yaml:"mode"`          // enforce（默认）/ permissive
+		Routes       []string `yaml:"routes"`        // 需要校验的路由，默认 intl
+		TrustAnchors []string `yaml:"trust_anchors"` // 信任锚 DS 记录，默认根区 KSK
+	} `yaml:"dnssec"`
+
+	// 污染应答过滤：应答包含投毒地址时丢弃，china 路由被污染时自动改走 intl 并记录为 GFW 学习候选
+	PoisonFilter struct {
+		Enabled        bool     `yaml:"enabled"`
+		BogusNXDomain  []string `yaml:"bogus_nxdomain"`  // 额外的投毒 IP / CIDR
+		AllowReserved  bool     `yaml:"allow_reserved"`  // 不过滤保留地址段（环回、组播等）
+		DisableBuiltin bool     `yaml:"disable_builtin"` // 不使用内置的 GFW 投毒地址列表
+		WaitMs         int      `yaml:"wait_ms"`         // UDP 收到污染应答后继续等待真实应答的时间，0 表示不等待
+		Routes         []string `yaml:"routes"`          // 过滤的路由，默认 china / intl
+	} `yaml:"poison_filter"`
+
+	// 规则学习：记录未命中规则的域名在 china -> intl 回退中的解析结果（需 SQLite），
+	// 连续 threshold 次结果一致时自动学习为 china 或 gfw 规则，可通过管理 API 确认或拒绝
+	Learning struct {
+		Enabled   bool `yaml:"enabled"`
+		Threshold int  `yaml:"threshold"` // 连续一致的观测次数，默认 5
+	} `yaml:"learning"`
+
+	// 条件转发区域：区域内的查询只发往指定上游，先于分流与广告拦截匹配
+	ForwardZones []ForwardZone `yaml:"forward_zones"`
+
+	// 私有地址反向解析 (RFC 6303)：配置上游时转发到局域网 DNS，否则本地返回 NXDOMAIN，
+	// 任何情况下都不会发往 china / intl 上游
+	PrivatePTR struct {
+		Upstreams []string `yaml:"upstreams"`
+	} `yaml:"private_ptr"`
+
+	// 本地权威记录（在缓存与上游之前应答）
+	LocalRecords []LocalRecord `yaml:"local_records"`
+	HostsFile    string        `yaml:"hosts_file"`
+
+	// 中国 IP 校验：未命中规则的域名，china 上游返回的 A/AAAA 需全部位于中国 IP 段，否则改走 intl
+	ChinaIP struct {
+		Enabled        bool   `yaml:"enabled"`
+		File           string `yaml:"file"`
+		URL            string `yaml:"url"`
+		UpdateInterval int    `yaml:"update_interval"`
+	} `yaml:"china_ip"`
+
 	// 规则同步配置
 	Sync struct {
 		Enabled  bool              `yaml:"enabled"`
@@ -70,6 +169,24 @@ type Config struct {
 	// 代理规则配置
 	ProxyRules []ProxyRule `yaml:"proxy_rules,omitempty"`
 
+	// DNS 缓存
+	Cache struct {
+		MinTTL      int `yaml:"min_ttl"`      // 正常应答的最小缓存时间（秒），默认 0
+		MaxTTL      int `yaml:"max_ttl"`      // 最大缓存时间（秒），默认 86400
+		NegativeTTL int `yaml:"negative_ttl"` // NXDOMAIN / NODATA 的最大缓存时间（秒），默认 3600
+
+		// serve-stale (RFC 8767)：上游失败或响应过慢时返回过期应答
+		ServeStale           bool `yaml:"serve_stale"`
+		StaleWindow          int  `yaml:"stale_window"`            // 过期后仍可返回的时长（秒），默认 3600
+		StaleTTL             int  `yaml:"stale_ttl"`               // 过期应答的 TTL（秒），默认 30
+		StaleAnswerTimeoutMs int  `yaml:"stale_answer_timeout_ms"` // 上游超过该时间未应答时先返回过期应答，默认 1800
+
+		// 预取：命中次数达到 prefetch_hits 的条目在剩余 TTL 不超过 prefetch_window 秒时后台刷新
+		Prefetch       bool `yaml:"prefetch"`
+		PrefetchHits   int  `yaml:"prefetch_hits"`   // 默认 5
+		PrefetchWindow int  `yaml:"prefetch_window"` // 默认 10
+	} `yaml:"cache"`
+
 	// 数据持久化配置
 	Persistence struct {
 		Enabled  bool   `yaml:"enabled"`
@@ -88,6 +205,19 @@ type Config struct {
 	} `yaml:"persistence"`
 }
 
+// IsEncryptedListenEnabled 是否配置了任一加密 DNS 监听
+func (c *Config) IsEncryptedListenEnabled() bool {
+	return c.ListenDoH != "" || c.ListenDoT != "" || c.ListenDoQ != ""
+}
+
+// GetDoHPath 获取 DoH 服务路径
+func (c *Config) GetDoHPath() string {
+	if c.DoHPath == "" {
+		return "/dns-query"
+	}
+	return c.DoHPath
+}
+
 // GetChinaUpstreams 获取中国上游DNS服务器
 func (c *Config) GetChinaUpstreams() []string {
 	return c.Upstreams.China
@@ -103,6 +233,192 @@ func (c *Config) GetAdguardUpstreams() []string {
 	return c.Upstreams.Adguard
 }
 
+// GetUpstreamGroup 按名称获取上游组
+func (c *Config) GetUpstreamGroup(name string) []string {
+	switch name {
+	case UpstreamGroupChina:
+		return c.GetChinaUpstreams()
+	case UpstreamGroupIntl:
+		return c.GetIntlUpstreams()
+	case UpstreamGroupAdguard:
+		return c.GetAdguardUpstreams()
+	}
+	return nil
+}
+
+// GetRootHints 获取递归解析的根提示
+func (c *Config) GetRootHints() []string {
+	if len(c.Upstreams.Recursive.RootHints) == 0 {
+		return defaultRootHints
+	}
+	return c.Upstreams.Recursive.RootHints
+}
+
+// GetRecursivePort 获取递归解析访问权威服务器的端口
+func (c *Config) GetRecursivePort() int {
+	if p := c.Upstreams.Recursive.Port; p > 0 && p < 65536 {
+		return p
+	}
+	return 53
+}
+
+// IsRecursiveCategory 判断分类（china / gfw / others）是否直接走递归解析
+func (c *Config) IsRecursiveCategory(category string) bool {
+	if !c.Upstreams.Recursive.Enabled {
+		return false
+	}
+	for _, cat := range c.Upstreams.Recursive.Categories {
+		if strings.EqualFold(strings.TrimSpace(cat), category) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUpstreamStrategy 获取上游组的选择策略，默认 sequential
+func (c *Config) GetUpstreamStrategy(group string) string {
+	strategy := strings.ToLower(strings.TrimSpace(c.Upstreams.Options[group].Strategy))
+	if IsValidUpstreamStrategy(strategy) {
+		return strategy
+	}
+	return StrategySequential
+}
+
+// GetUpstreamTimeout 获取上游组单次尝试的超时时间
+func (c *Config) GetUpstreamTimeout(group string) time.Duration {
+	if ms := c.Upstreams.Options[group].TimeoutMs; ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	if group == RecursiveUpstream {
+		// 冷缓存时递归解析需要多次往返
+		return defaultRecursiveTimeout
+	}
+	return defaultUpstreamTimeout
+}
+
+// GetCircuitFailThreshold 获取上游组的熔断失败阈值
+func (c *Config) GetCircuitFailThreshold(group string) int {
+	if n := c.Upstreams.Options[group].FailThreshold; n > 0 {
+		return n
+	}
+	return defaultCircuitFailThreshold
+}
+
+// GetCircuitOpenDuration 获取上游组的熔断持续时间
+func (c *Config) GetCircuitOpenDuration(group string) time.Duration {
+	if sec := c.Upstreams.Options[group].OpenSeconds; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return defaultCircuitOpenDuration
+}
+
+// GetCacheMinTTL 获取正常应答的最小缓存时间
+func (c *Config) GetCacheMinTTL() uint32 {
+	if c.Cache.MinTTL <= 0 {
+		return 0
+	}
+	return uint32(c.Cache.MinTTL)
+}
+
+// GetCacheMaxTTL 获取最大缓存时间
+func (c *Config) GetCacheMaxTTL() uint32 {
+	if c.Cache.MaxTTL <= 0 {
+		return defaultCacheMaxTTL
+	}
+	return uint32(c.Cache.MaxTTL)
+}
+
+// GetCacheNegativeTTL 获取否定应答的最大缓存时间
+func (c *Config) GetCacheNegativeTTL() uint32 {
+	if c.Cache.NegativeTTL <= 0 {
+		return defaultCacheNegativeTTL
+	}
+	return uint32(c.Cache.NegativeTTL)
+}
+
+// GetStaleWindow 获取 serve-stale 窗口，未启用时为 0
+func (c *Config) GetStaleWindow() time.Duration {
+	if !c.Cache.ServeStale {
+		return 0
+	}
+	if c.Cache.StaleWindow <= 0 {
+		return time.Hour
+	}
+	return time.Duration(c.Cache.StaleWindow) * time.Second
+}
+
+// GetStaleTTL 获取过期应答的 TTL
+func (c *Config) GetStaleTTL() uint32 {
+	if c.Cache.StaleTTL <= 0 {
+		return 30
+	}
+	return uint32(c.Cache.StaleTTL)
+}
+
+// GetStaleAnswerTimeout 获取返回过期应答前等待上游的时间
+func (c *Config) GetStaleAnswerTimeout() time.Duration {
+	if c.Cache.StaleAnswerTimeoutMs <= 0 {
+		return 1800 * time.Millisecond
+	}
+	return time.Duration(c.Cache.StaleAnswerTimeoutMs) * time.Millisecond
+}
+
+// GetPrefetchHits 获取触发预取的最小命中次数
+func (c *Config) GetPrefetchHits() int {
+	if c.Cache.PrefetchHits <= 0 {
+		return 5
+	}
+	return c.Cache.PrefetchHits
+}
+
+// GetPrefetchWindow 获取预取窗口
+func (c *Config) GetPrefetchWindow() time.Duration {
+	if c.Cache.PrefetchWindow <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.Cache.PrefetchWindow) * time.Second
+}
+
+// GetDNSSECMode 获取 DNSSEC 校验模式
+func (c *Config) GetDNSSECMode() string {
+	if strings.EqualFold(c.DNSSEC.Mode, DNSSECModePermissive) {
+		return DNSSECModePermissive
+	}
+	return DNSSECModeEnforce
+}
+
+// GetDNSSECRoutes 获取需要 DNSSEC 校验的路由
+func (c *Config) GetDNSSECRoutes() []string {
+	if len(c.DNSSEC.Routes) == 0 {
+		return []string{UpstreamGroupIntl}
+	}
+	return c.DNSSEC.Routes
+}
+
+// GetPoisonWait 获取收到污染应答后等待真实应答的时间
+func (c *Config) GetPoisonWait() time.Duration {
+	if c.PoisonFilter.WaitMs <= 0 {
+		return 0
+	}
+	return time.Duration(c.PoisonFilter.WaitMs) * time.Millisecond
+}
+
+// GetPoisonFilterRoutes 获取启用污染过滤的路由
+func (c *Config) GetPoisonFilterRoutes() []string {
+	if len(c.PoisonFilter.Routes) == 0 {
+		return []string{UpstreamGroupChina, UpstreamGroupIntl}
+	}
+	return c.PoisonFilter.Routes
+}
+
+// GetLearningThreshold 获取自动学习所需的连续一致观测次数
+func (c *Config) GetLearningThreshold() int {
+	if c.Learning.Threshold <= 0 {
+		return defaultLearningThreshold
+	}
+	return c.Learning.Threshold
+}
+
 // GetChinaDomains 获取中国域名列表
 func (c *Config) GetChinaDomains() []string {
 	return c.Domains.China
@@ -118,6 +434,74 @@ func (c *Config) GetAdsDomains() []string {
 	return c.Domains.Ads
 }
 
+// GetBlockingMode 获取广告拦截模式：未配置时与旧版本一致转发到 adguard 上游，配置无效时按 null 处理
+func (c *Config) GetBlockingMode() string {
+	mode := strings.ToLower(strings.TrimSpace(c.Blocking.Mode))
+	if mode == "" {
+		return BlockModeUpstream
+	}
+	if !IsValidBlockMode(mode) {
+		return BlockModeNull
+	}
+	return mode
+}
+
+// GetBlockingTTL 获取拦截应答的 TTL
+func (c *Config) GetBlockingTTL() uint32 {
+	if c.Blocking.TTL == 0 {
+		return defaultBlockTTL
+	}
+	return c.Blocking.TTL
+}
+
+// GetRateLimitSlip 获取 slip 值（默认 2，与 BIND 一致）
+func (c *Config) GetRateLimitSlip() int {
+	if c.Security.Slip < 0 {
+		return 0
+	}
+	if c.Security.Slip == 0 {
+		return 2
+	}
+	return c.Security.Slip
+}
+
+// GetRateLimitIPv4Prefix 获取 IPv4 客户端归并前缀长度
+func (c *Config) GetRateLimitIPv4Prefix() int {
+	if c.Security.IPv4Prefix <= 0 || c.Security.IPv4Prefix > 32 {
+		return 24
+	}
+	return c.Security.IPv4Prefix
+}
+
+// GetRateLimitIPv6Prefix 获取 IPv6 客户端归并前缀长度
+func (c *Config) GetRateLimitIPv6Prefix() int {
+	if c.Security.IPv6Prefix <= 0 || c.Security.IPv6Prefix > 128 {
+		return 56
+	}
+	return c.Security.IPv6Prefix
+}
+
+// IsChinaIPVerifyEnabled 是否启用中国 IP 校验
+func (c *Config) IsChinaIPVerifyEnabled() bool {
+	return c.ChinaIP.Enabled
+}
+
+// GetChinaIPFile 获取中国 IP 列表文件路径
+func (c *Config) GetChinaIPFile() string {
+	if c.ChinaIP.File == "" {
+		return filepath.Join(c.GetDataDir(), "china_ip.txt")
+	}
+	return c.ChinaIP.File
+}
+
+// GetChinaIPUpdateInterval 获取中国 IP 列表订阅更新间隔
+func (c *Config) GetChinaIPUpdateInterval() time.Duration {
+	if c.ChinaIP.UpdateInterval <= 0 {
+		return 24 * time.Hour // 默认1天
+	}
+	return time.Duration(c.ChinaIP.UpdateInterval) * time.Second
+}
+
 // GetSyncInterval 获取同步间隔
 func (c *Config) GetSyncInterval() time.Duration {
 	if c.Sync.Interval <= 0 {
@@ -0,0 +1,744 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DNSSEC 校验结果
+const (
+	DNSSECSecure   = "secure"   // 信任链完整且签名有效
+	DNSSECInsecure = "insecure" // 可证明该区未签名（父区无 DS）
+	DNSSECBogus    = "bogus"    // 签名缺失、无效或信任链断裂
+)
+
+// DNSSEC 校验模式
+const (
+	DNSSECModeEnforce    = "enforce"    // bogus 应答返回 SERVFAIL
+	DNSSECModePermissive = "permissive" // bogus 应答仅记录日志
+)
+
+// rootTrustAnchors 根区 KSK 的 DS 记录（KSK-2017 与 KSK-2024）
+var rootTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// 区密钥缓存时间：已验证的 DNSKEY 按 TTL 缓存（不超过一天），不安全区固定缓存一小时
+const (
+	maxZoneKeysTTL       = 24 * time.Hour
+	insecureZoneCacheTTL = time.Hour
+)
+
+// errDNSSECBogus 校验失败
+var errDNSSECBogus = errors.New("DNSSEC 校验失败")
+
+var dnssecValidations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "boomdns_dnssec_validations_total",
+		Help: "DNSSEC validation results by route",
+	},
+	[]string{"target", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(dnssecValidations)
+}
+
+// zoneKeys 已验证的区密钥，keys 为 nil 表示不安全区
+type zoneKeys struct {
+	keys     []*mdns.DNSKEY
+	expireAt time.Time
+}
+
+// dnssecValidator 从信任锚逐级验证 DS / DNSKEY 并校验应答签名
+type dnssecValidator struct {
+	s       *Server
+	mode    string
+	routes  map[string]bool
+	anchors []*mdns.DS
+
+	mu    sync.Mutex
+	zones map[string]*zoneKeys
+}
+
+// newDNSSECValidator 按配置创建校验器，未启用时返回 nil
+func newDNSSECValidator(s *Server, cfg *Config) *dnssecValidator {
+	if !cfg.DNSSEC.Enabled {
+		return nil
+	}
+	v := &dnssecValidator{
+		s:      s,
+		mode:   cfg.GetDNSSECMode(),
+		routes: make(map[string]bool),
+		zones:  make(map[string]*zoneKeys),
+	}
+	for _, r := range cfg.GetDNSSECRoutes() {
+		v.routes[strings.ToLower(strings.TrimSpace(r))] = true
+	}
+	anchors := cfg.DNSSEC.TrustAnchors
+	if len(anchors) == 0 {
+		anchors = rootTrustAnchors
+	}
+	for _, a := range anchors {
+		rr, err := mdns.NewRR(a)
+		if err != nil {
+			log.Printf("忽略无效的信任锚 %q: %v", a, err)
+			continue
+		}
+		ds, ok := rr.(*mdns.DS)
+		if !ok {
+			log.Printf("信任锚必须为 DS 记录: %q", a)
+			continue
+		}
+		ds.Hdr.Name = mdns.CanonicalName(ds.Hdr.Name)
+		v.anchors = append(v.anchors, ds)
+	}
+	return v
+}
+
+// enabledFor 判断路由是否启用 DNSSEC 校验
+func (v *dnssecValidator) enabledFor(target string) bool {
+	return v != nil && v.routes[upstreamGroupOf(target)]
+}
+
+// prepareDNSSEC 生成带 DO 与 CD 位的请求副本，由本地完成校验
+func prepareDNSSEC(req *mdns.Msg) *mdns.Msg {
+	out := req.Copy()
+	if opt := out.IsEdns0(); opt != nil {
+		opt.SetDo()
+	} else {
+		out.SetEdns0(mdns.DefaultMsgSize, true)
+	}
+	out.CheckingDisabled = true
+	return out
+}
+
+// finishDNSSEC 按客户端请求整理已校验的应答：安全时设置 AD（客户端携带 DO 或 AD 时），
+// 客户端未携带 DO 时去掉 RRSIG / NSEC / NSEC3
+func finishDNSSEC(resp, req *mdns.Msg, status string) {
+	resp.CheckingDisabled = req.CheckingDisabled
+	clientDO := false
+	if opt := req.IsEdns0(); opt != nil {
+		clientDO = opt.Do()
+	}
+	resp.AuthenticatedData = status == DNSSECSecure && (clientDO || req.AuthenticatedData)
+	if clientDO {
+		return
+	}
+	qtype := req.Question[0].Qtype
+	strip := func(rrs []mdns.RR) []mdns.RR {
+		out := rrs[:0]
+		for _, rr := range rrs {
+			t := rr.Header().Rrtype
+			if t != qtype && (t == mdns.TypeRRSIG || t == mdns.TypeNSEC || t == mdns.TypeNSEC3) {
+				continue
+			}
+			out = append(out, rr)
+		}
+		return out
+	}
+	resp.Answer = strip(resp.Answer)
+	resp.Ns = strip(resp.Ns)
+	resp.Extra = strip(resp.Extra)
+	if opt := resp.IsEdns0(); opt != nil {
+		opt.SetDo(false)
+	}
+}
+
+// validate 校验应答：肯定应答校验 Answer 中的全部 RRset；否定应答校验权威区的 SOA / NSEC / NSEC3 签名，
+// 并要求 NSEC / NSEC3 证明名称或类型确实不存在。问题取自客户端请求，不信任上游应答的问题部分
+func (v *dnssecValidator) validate(ctx context.Context, q mdns.Question, resp *mdns.Msg, ups []string, target string) (string, error) {
+	records := resp.Answer
+	if len(records) == 0 {
+		for _, rr := range resp.Ns {
+			switch rr.Header().Rrtype {
+			case mdns.TypeSOA, mdns.TypeNSEC, mdns.TypeNSEC3, mdns.TypeRRSIG:
+				records = append(records, rr)
+			}
+		}
+	}
+	sets, sigs := splitRRsets(records)
+	if len(sets) == 0 {
+		// 没有可校验的记录：所在区已签名时视为 bogus
+		return v.unsignedStatus(ctx, q.Name, ups, target)
+	}
+
+	now := time.Now()
+	status := DNSSECSecure
+	for key, set := range sets {
+		st, err := v.verifySet(ctx, set, sigs[key], ups, target, now)
+		if st == DNSSECBogus {
+			return st, err
+		}
+		if st == DNSSECInsecure {
+			status = DNSSECInsecure
+		}
+	}
+	if len(resp.Answer) == 0 && status == DNSSECSecure {
+		status = denialStatus(q, resp.Rcode == mdns.RcodeNameError, records)
+		if status == DNSSECBogus {
+			return status, fmt.Errorf("%s %s 的否定应答缺少有效的 NSEC / NSEC3 证明", q.Name, mdns.TypeToString[q.Qtype])
+		}
+	}
+	return status, nil
+}
+
+// verifySet 校验单个 RRset
+func (v *dnssecValidator) verifySet(ctx context.Context, set []mdns.RR, sigs []*mdns.RRSIG, ups []string, target string, now time.Time) (string, error) {
+	owner := set[0].Header().Name
+	rtype := mdns.TypeToString[set[0].Header().Rrtype]
+	if len(sigs) == 0 {
+		return v.unsignedStatus(ctx, owner, ups, target)
+	}
+	signer := mdns.CanonicalName(sigs[0].SignerName)
+	if !mdns.IsSubDomain(signer, mdns.CanonicalName(owner)) {
+		return DNSSECBogus, fmt.Errorf("%s %s 的签名者 %s 不是其上级区", owner, rtype, signer)
+	}
+	keys, err := v.zoneKeys(ctx, signer, ups, target)
+	if err != nil {
+		return DNSSECBogus, err
+	}
+	if keys == nil {
+		return DNSSECInsecure, nil
+	}
+	if !verifyRRset(set, sigs, keys, now) {
+		return DNSSECBogus, fmt.Errorf("%s %s 签名无效", owner, rtype)
+	}
+	return DNSSECSecure, nil
+}
+
+// unsignedStatus 判断未签名记录所在区的状态：区已签名时为 bogus，可证明未签名时为 insecure
+func (v *dnssecValidator) unsignedStatus(ctx context.Context, name string, ups []string, target string) (string, error) {
+	zone, err := v.findZone(ctx, name, ups, target)
+	if err != nil {
+		return DNSSECBogus, err
+	}
+	keys, err := v.zoneKeys(ctx, zone, ups, target)
+	if err != nil {
+		return DNSSECBogus, err
+	}
+	if keys != nil {
+		return DNSSECBogus, fmt.Errorf("%s 位于已签名区 %s 但缺少签名", name, zone)
+	}
+	return DNSSECInsecure, nil
+}
+
+// findZone 通过 SOA 查询确定名称所在的区
+func (v *dnssecValidator) findZone(ctx context.Context, name string, ups []string, target string) (string, error) {
+	resp, err := v.query(ctx, name, mdns.TypeSOA, ups, target)
+	if err != nil {
+		return "", err
+	}
+	soa := findSOA(resp.Answer)
+	if soa == nil {
+		soa = findSOA(resp.Ns)
+	}
+	if soa == nil {
+		return "", fmt.Errorf("无法确定 %s 所在的区", name)
+	}
+	zone := mdns.CanonicalName(soa.Hdr.Name)
+	if !mdns.IsSubDomain(zone, mdns.CanonicalName(name)) {
+		return "", fmt.Errorf("%s 的 SOA 属于无关的区 %s", name, zone)
+	}
+	return zone, nil
+}
+
+// zoneKeys 返回区的已验证 DNSKEY；不安全区返回 nil。
+// 根区以信任锚验证，其余区以父区签名的 DS 验证，父区证明无 DS 时为不安全区
+func (v *dnssecValidator) zoneKeys(ctx context.Context, zone string, ups []string, target string) ([]*mdns.DNSKEY, error) {
+	zone = mdns.CanonicalName(zone)
+	v.mu.Lock()
+	if zk := v.zones[zone]; zk != nil && time.Now().Before(zk.expireAt) {
+		v.mu.Unlock()
+		return zk.keys, nil
+	}
+	v.mu.Unlock()
+
+	var keys []*mdns.DNSKEY
+	var ttl time.Duration
+	var err error
+	if zone == "." {
+		keys, ttl, err = v.fetchKeys(ctx, zone, v.anchors, ups, target)
+	} else {
+		keys, ttl, err = v.delegatedKeys(ctx, zone, ups, target)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.zones[zone] = &zoneKeys{keys: keys, expireAt: time.Now().Add(ttl)}
+	v.mu.Unlock()
+	return keys, nil
+}
+
+// delegatedKeys 通过父区的 DS 验证子区密钥
+func (v *dnssecValidator) delegatedKeys(ctx context.Context, zone string, ups []string, target string) ([]*mdns.DNSKEY, time.Duration, error) {
+	resp, err := v.query(ctx, zone, mdns.TypeDS, ups, target)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sets, sigs := splitRRsets(resp.Answer)
+	dsKey := zone + ":" + mdns.TypeToString[mdns.TypeDS]
+	if dsSet := sets[dsKey]; len(dsSet) > 0 {
+		dsSigs := sigs[dsKey]
+		if len(dsSigs) == 0 {
+			// 未签名的 DS 仅在父区不安全时可接受
+			st, err := v.unsignedStatus(ctx, parentName(zone), ups, target)
+			if st != DNSSECInsecure {
+				return nil, 0, fmt.Errorf("%s 的 DS 缺少签名: %v", zone, err)
+			}
+			return nil, insecureZoneCacheTTL, nil
+		}
+		parent := mdns.CanonicalName(dsSigs[0].SignerName)
+		if !isProperAncestor(parent, zone) {
+			return nil, 0, fmt.Errorf("%s 的 DS 签名者 %s 不是其父区", zone, parent)
+		}
+		pkeys, err := v.zoneKeys(ctx, parent, ups, target)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pkeys == nil {
+			return nil, insecureZoneCacheTTL, nil
+		}
+		if !verifyRRset(dsSet, dsSigs, pkeys, time.Now()) {
+			return nil, 0, fmt.Errorf("%s 的 DS 签名无效", zone)
+		}
+		var ds []*mdns.DS
+		for _, rr := range dsSet {
+			ds = append(ds, rr.(*mdns.DS))
+		}
+		return v.fetchKeys(ctx, zone, ds, ups, target)
+	}
+
+	// 无 DS：需父区签名的 NSEC / NSEC3 证明
+	var denial []mdns.RR
+	for _, rr := range resp.Ns {
+		switch rr.Header().Rrtype {
+		case mdns.TypeNSEC, mdns.TypeNSEC3, mdns.TypeRRSIG:
+			denial = append(denial, rr)
+		}
+	}
+	nsets, nsigs := splitRRsets(denial)
+	if len(nsets) == 0 {
+		soa := findSOA(resp.Ns)
+		if soa == nil || !isProperAncestor(mdns.CanonicalName(soa.Hdr.Name), zone) {
+			return nil, 0, fmt.Errorf("%s 的 DS 否定应答缺少证明", zone)
+		}
+		pkeys, err := v.zoneKeys(ctx, soa.Hdr.Name, ups, target)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pkeys != nil {
+			return nil, 0, fmt.Errorf("%s 的 DS 否定应答未签名", zone)
+		}
+		return nil, insecureZoneCacheTTL, nil
+	}
+
+	now := time.Now()
+	for key, set := range nsets {
+		setSigs := nsigs[key]
+		if len(setSigs) == 0 {
+			return nil, 0, fmt.Errorf("%s 的 DS 否定证明缺少签名", zone)
+		}
+		parent := mdns.CanonicalName(setSigs[0].SignerName)
+		if !isProperAncestor(parent, zone) {
+			return nil, 0, fmt.Errorf("%s 的 DS 否定证明签名者 %s 不是其父区", zone, parent)
+		}
+		pkeys, err := v.zoneKeys(ctx, parent, ups, target)
+		if err != nil {
+			return nil, 0, err
+		}
+		if pkeys == nil {
+			return nil, insecureZoneCacheTTL, nil
+		}
+		if !verifyRRset(set, setSigs, pkeys, now) {
+			return nil, 0, fmt.Errorf("%s 的 DS 否定证明签名无效", zone)
+		}
+	}
+	if !provesNoDS(zone, denial) {
+		return nil, 0, fmt.Errorf("%s 的 DS 否定证明不成立", zone)
+	}
+	return nil, insecureZoneCacheTTL, nil
+}
+
+// fetchKeys 查询区的 DNSKEY，要求其中匹配 DS（或信任锚）的密钥对整个 DNSKEY RRset 签名
+func (v *dnssecValidator) fetchKeys(ctx context.Context, zone string, ds []*mdns.DS, ups []string, target string) ([]*mdns.DNSKEY, time.Duration, error) {
+	if len(ds) == 0 {
+		return nil, 0, fmt.Errorf("%s 没有可用的信任锚", zone)
+	}
+	resp, err := v.query(ctx, zone, mdns.TypeDNSKEY, ups, target)
+	if err != nil {
+		return nil, 0, err
+	}
+	sets, sigs := splitRRsets(resp.Answer)
+	key := zone + ":" + mdns.TypeToString[mdns.TypeDNSKEY]
+	set := sets[key]
+	if len(set) == 0 {
+		return nil, 0, fmt.Errorf("%s 没有 DNSKEY", zone)
+	}
+
+	var keys, anchored []*mdns.DNSKEY
+	ttl := maxZoneKeysTTL
+	for _, rr := range set {
+		k := rr.(*mdns.DNSKEY)
+		keys = append(keys, k)
+		if t := time.Duration(k.Hdr.Ttl) * time.Second; t < ttl {
+			ttl = t
+		}
+		for _, d := range ds {
+			if d.KeyTag == k.KeyTag() && d.Algorithm == k.Algorithm {
+				if digest := k.ToDS(d.DigestType); digest != nil && strings.EqualFold(digest.Digest, d.Digest) {
+					anchored = append(anchored, k)
+				}
+			}
+		}
+	}
+	if len(anchored) == 0 {
+		return nil, 0, fmt.Errorf("%s 的 DNSKEY 与 DS 不匹配", zone)
+	}
+	if !verifyRRset(set, sigs[key], anchored, time.Now()) {
+		return nil, 0, fmt.Errorf("%s 的 DNSKEY 签名无效", zone)
+	}
+	return keys, ttl, nil
+}
+
+// query 发送带 DO / CD 位的辅助查询（DS / DNSKEY / SOA）
+func (v *dnssecValidator) query(ctx context.Context, name string, qtype uint16, ups []string, target string) (*mdns.Msg, error) {
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(name), qtype)
+	m.SetEdns0(4096, true)
+	m.CheckingDisabled = true
+	resp, _, err := v.s.forward(ctx, m, defaultPolicy, ups, target)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != mdns.RcodeSuccess && resp.Rcode != mdns.RcodeNameError {
+		return nil, fmt.Errorf("查询 %s %s 失败: %s", name, mdns.TypeToString[qtype], mdns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+// splitRRsets 将记录按 (名称, 类型) 分组，RRSIG 按其覆盖的类型归组
+func splitRRsets(rrs []mdns.RR) (map[string][]mdns.RR, map[string][]*mdns.RRSIG) {
+	sets := make(map[string][]mdns.RR)
+	sigs := make(map[string][]*mdns.RRSIG)
+	for _, rr := range rrs {
+		h := rr.Header()
+		name := mdns.CanonicalName(h.Name)
+		switch r := rr.(type) {
+		case *mdns.RRSIG:
+			key := name + ":" + mdns.TypeToString[r.TypeCovered]
+			sigs[key] = append(sigs[key], r)
+		case *mdns.OPT:
+		default:
+			key := name + ":" + mdns.TypeToString[h.Rrtype]
+			sets[key] = append(sets[key], rr)
+		}
+	}
+	return sets, sigs
+}
+
+// verifyRRset 任一在有效期内、由给定密钥生成的签名验证通过即可
+func verifyRRset(set []mdns.RR, sigs []*mdns.RRSIG, keys []*mdns.DNSKEY, now time.Time) bool {
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, k := range keys {
+			if k.Flags&mdns.ZONE == 0 || k.KeyTag() != sig.KeyTag || k.Algorithm != sig.Algorithm {
+				continue
+			}
+			if !strings.EqualFold(k.Hdr.Name, sig.SignerName) {
+				continue
+			}
+			if sig.Verify(k, set) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// provesNoDS 判断 NSEC / NSEC3 是否证明 zone 是没有 DS 的委派：
+// 精确匹配的记录含 NS 且不含 DS、SOA，或 opt-out 的 NSEC3 覆盖该名称
+func provesNoDS(zone string, rrs []mdns.RR) bool {
+	for _, rr := range rrs {
+		switch n := rr.(type) {
+		case *mdns.NSEC:
+			if strings.EqualFold(n.Hdr.Name, zone) && isDelegationWithoutDS(n.TypeBitMap) {
+				return true
+			}
+		case *mdns.NSEC3:
+			if n.Match(zone) && isDelegationWithoutDS(n.TypeBitMap) {
+				return true
+			}
+			if n.Flags&1 == 1 && n.Cover(zone) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// denialStatus 校验否定应答的存在性证明 (RFC 4035 5.4, RFC 5155 8.4-8.7)，rrs 的签名需已验证：
+// NXDOMAIN 需证明名称与最近祖先下的通配符均不存在；NODATA 需证明名称（或匹配的通配符）存在但不含查询类型。
+// 证明成立时为 secure，依赖 NSEC3 opt-out 时为 insecure，否则为 bogus
+func denialStatus(q mdns.Question, nxdomain bool, rrs []mdns.RR) string {
+	var nsecs []*mdns.NSEC
+	var nsec3s []*mdns.NSEC3
+	for _, rr := range rrs {
+		switch n := rr.(type) {
+		case *mdns.NSEC:
+			nsecs = append(nsecs, n)
+		case *mdns.NSEC3:
+			nsec3s = append(nsec3s, n)
+		}
+	}
+	name := mdns.CanonicalName(q.Name)
+	switch {
+	case len(nsecs) > 0:
+		if nsecDenial(name, q.Qtype, nxdomain, nsecs) {
+			return DNSSECSecure
+		}
+	case len(nsec3s) > 0:
+		return nsec3Denial(name, q.Qtype, nxdomain, nsec3s)
+	}
+	return DNSSECBogus
+}
+
+// nsecDenial NSEC 证明：NODATA 时名称的 NSEC 不含查询类型、名称为空非终端节点，或名称不存在而匹配的通配符不含查询类型；
+// NXDOMAIN 时名称与 *.<最近祖先> 均被 NSEC 覆盖
+func nsecDenial(name string, qtype uint16, nxdomain bool, nsecs []*mdns.NSEC) bool {
+	if !nxdomain {
+		for _, n := range nsecs {
+			if strings.EqualFold(n.Hdr.Name, name) {
+				return !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, mdns.TypeCNAME)
+			}
+		}
+	}
+	for _, n := range nsecs {
+		if !nsecCovers(n, name) {
+			continue
+		}
+		if !nxdomain && mdns.IsSubDomain(name, n.NextDomain) {
+			// 空非终端节点：名称本身没有记录，但存在下级名称
+			return true
+		}
+		wildcard := "*." + strings.TrimPrefix(nsecClosestEncloser(name, n), ".")
+		for _, w := range nsecs {
+			if nxdomain && nsecCovers(w, wildcard) {
+				return true
+			}
+			if !nxdomain && strings.EqualFold(w.Hdr.Name, wildcard) {
+				return !hasType(w.TypeBitMap, qtype) && !hasType(w.TypeBitMap, mdns.TypeCNAME)
+			}
+		}
+	}
+	return false
+}
+
+// nsecCovers 判断 name 是否位于 NSEC 的所有者与下一名称之间（按规范顺序）
+func nsecCovers(n *mdns.NSEC, name string) bool {
+	owner, next := n.Hdr.Name, n.NextDomain
+	if compareCanonical(owner, next) < 0 {
+		return compareCanonical(owner, name) < 0 && compareCanonical(name, next) < 0
+	}
+	// 区内最后一条 NSEC，下一名称回绕到区顶点
+	return mdns.IsSubDomain(next, name) && compareCanonical(owner, name) < 0
+}
+
+// nsecClosestEncloser 由覆盖 name 的 NSEC 推出最近的存在祖先：name 与所有者、下一名称的最长公共祖先
+func nsecClosestEncloser(name string, n *mdns.NSEC) string {
+	common := mdns.CompareDomainName(name, n.Hdr.Name)
+	if c := mdns.CompareDomainName(name, n.NextDomain); c > common {
+		common = c
+	}
+	return ancestorWithLabels(name, common)
+}
+
+// nsec3Denial NSEC3 证明：NODATA 时名称的 NSEC3 不含查询类型；否则需最近祖先证明，
+// NXDOMAIN 时通配符被覆盖，NODATA 时匹配的通配符不含查询类型；覆盖下一名称的 NSEC3 带 opt-out 时为 insecure
+func nsec3Denial(name string, qtype uint16, nxdomain bool, nsec3s []*mdns.NSEC3) string {
+	if !nxdomain {
+		for _, n := range nsec3s {
+			if n.Match(name) {
+				if !hasType(n.TypeBitMap, qtype) && !hasType(n.TypeBitMap, mdns.TypeCNAME) {
+					return DNSSECSecure
+				}
+				return DNSSECBogus
+			}
+		}
+	}
+
+	encloser, optOut, ok := nsec3ClosestEncloser(name, nsec3s)
+	if !ok {
+		return DNSSECBogus
+	}
+	if !nxdomain && qtype == mdns.TypeDS && optOut {
+		// 未签名委派的 DS 查询 (RFC 5155 8.6)
+		return DNSSECInsecure
+	}
+	wildcard := "*." + strings.TrimPrefix(encloser, ".")
+	for _, n := range nsec3s {
+		if nxdomain && n.Cover(wildcard) {
+			if optOut {
+				return DNSSECInsecure
+			}
+			return DNSSECSecure
+		}
+		if !nxdomain && n.Match(wildcard) {
+			if hasType(n.TypeBitMap, qtype) || hasType(n.TypeBitMap, mdns.TypeCNAME) {
+				return DNSSECBogus
+			}
+			return DNSSECSecure
+		}
+	}
+	return DNSSECBogus
+}
+
+// nsec3ClosestEncloser 最近祖先证明 (RFC 5155 8.3)：自 name 向上寻找被 NSEC3 匹配的祖先，
+// 且其下一级的名称被另一条 NSEC3 覆盖；optOut 表示覆盖下一名称的 NSEC3 设置了 opt-out
+func nsec3ClosestEncloser(name string, nsec3s []*mdns.NSEC3) (encloser string, optOut, ok bool) {
+	labels := mdns.CountLabel(name)
+	for i := labels - 1; i >= 0; i-- {
+		candidate := ancestorWithLabels(name, i)
+		matched := false
+		for _, n := range nsec3s {
+			if n.Match(candidate) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		nextCloser := ancestorWithLabels(name, i+1)
+		for _, n := range nsec3s {
+			if n.Cover(nextCloser) {
+				return candidate, n.Flags&1 == 1, true
+			}
+		}
+		return "", false, false
+	}
+	return "", false, false
+}
+
+// ancestorWithLabels 返回 name 保留最右侧 n 个标签的祖先，n 为 0 时为根
+func ancestorWithLabels(name string, n int) string {
+	if n <= 0 {
+		return "."
+	}
+	return lastLabels(name, n)
+}
+
+// compareCanonical 按 DNS 规范顺序 (RFC 4034 6.1) 比较名称：自右向左逐个标签按小写的原始字节比较
+func compareCanonical(a, b string) int {
+	la, lb := canonicalLabels(a), canonicalLabels(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// canonicalLabels 将名称拆分为去掉转义、转为小写的标签
+func canonicalLabels(name string) [][]byte {
+	buf := make([]byte, 256)
+	end, err := mdns.PackDomainName(mdns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		var labels [][]byte
+		for _, l := range mdns.SplitDomainName(name) {
+			labels = append(labels, bytes.ToLower([]byte(l)))
+		}
+		return labels
+	}
+	var labels [][]byte
+	for i := 0; i < end && buf[i] != 0; i += int(buf[i]) + 1 {
+		labels = append(labels, bytes.ToLower(buf[i+1:i+1+int(buf[i])]))
+	}
+	return labels
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isDelegationWithoutDS(bitmap []uint16) bool {
+	hasNS, hasDS, hasSOA := false, false, false
+	for _, t := range bitmap {
+		switch t {
+		case mdns.TypeNS:
+			hasNS = true
+		case mdns.TypeDS:
+			hasDS = true
+		case mdns.TypeSOA:
+			hasSOA = true
+		}
+	}
+	return hasNS && !hasDS && !hasSOA
+}
+
+// isProperAncestor 判断 parent 是否为 child 的上级区（不含自身）
+func isProperAncestor(parent, child string) bool {
+	return !strings.EqualFold(parent, child) && mdns.IsSubDomain(parent, child)
+}
+
+// parentName 去掉最左侧的标签
+func parentName(name string) string {
+	if i, end := mdns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// forwardRoute 按路由转发：应用 ECS 策略，过滤污染应答，启用 DNSSEC 的路由在本地校验应答
+func (s *Server) forwardRoute(ctx context.Context, req *mdns.Msg, client netip.Addr, policy *clientPolicy, ups []string, target string) (*mdns.Msg, string, error) {
+	out := s.withECS(req, target, client)
+	// 客户端设置 CD 位时不做校验，由客户端自行处理
+	validate := s.dnssec.enabledFor(target) && !req.CheckingDisabled
+	if validate {
+		out = prepareDNSSEC(out)
+	}
+
+	resp, upstream, err := s.forward(ctx, out, policy, ups, target)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.checkPoisoned(resp, target); err != nil {
+		return nil, upstream, err
+	}
+	if validate {
+		status, verr := s.dnssec.validate(ctx, req.Question[0], resp, ups, target)
+		dnssecValidations.WithLabelValues(target, status).Inc()
+		if status == DNSSECBogus {
+			if s.dnssec.mode == DNSSECModeEnforce {
+				return nil, upstream, fmt.Errorf("%w: %v", errDNSSECBogus, verr)
+			}
+			log.Printf("DNSSEC 校验失败（宽松模式，仍返回应答） %s: %v", req.Question[0].Name, verr)
+		}
+		finishDNSSEC(resp, req, status)
+	}
+	if out != req {
+		restoreECS(resp, req)
+	}
+	return resp, upstream, nil
+}
@@ -0,0 +1,339 @@
+package dns
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// testZone 测试用的签名区，KSK 与 ZSK 共用一把密钥
+type testZone struct {
+	name string
+	key  *mdns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	t.Helper()
+	key := &mdns.DNSKEY{
+		Hdr:       mdns.RR_Header{Name: name, Rrtype: mdns.TypeDNSKEY, Class: mdns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: mdns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+// sign 为 RRset 生成签名
+func (z *testZone) sign(t *testing.T, set ...mdns.RR) *mdns.RRSIG {
+	t.Helper()
+	now := time.Now()
+	sig := &mdns.RRSIG{
+		Hdr:        mdns.RR_Header{Name: set[0].Header().Name, Rrtype: mdns.TypeRRSIG, Class: mdns.ClassINET, Ttl: set[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(z.priv, set); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// signed 返回 RRset 及其签名
+func (z *testZone) signed(t *testing.T, set ...mdns.RR) []mdns.RR {
+	return append(set, z.sign(t, set...))
+}
+
+// ds 返回区密钥的 DS 记录
+func (z *testZone) ds() *mdns.DS {
+	return z.key.ToDS(mdns.SHA256)
+}
+
+func mustRR(t *testing.T, s string) mdns.RR {
+	t.Helper()
+	rr, err := mdns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+// fakeUpstream 按 (名称, 类型) 返回预置应答的 UDP DNS 服务器，未预置的查询返回 REFUSED
+type fakeUpstream struct {
+	mu         sync.Mutex
+	answers    map[string]*mdns.Msg
+	noQuestion map[string]bool // 应答不带问题部分
+	addr       string
+}
+
+func startFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+	f := &fakeUpstream{answers: make(map[string]*mdns.Msg), noQuestion: make(map[string]bool)}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(f.serve)}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	f.addr = pc.LocalAddr().String()
+	return f
+}
+
+// set 预置应答，rcode 之后依次为 Answer 与 Ns 两节
+func (f *fakeUpstream) set(name string, qtype uint16, rcode int, answer, ns []mdns.RR) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers[strings.ToLower(name)+":"+mdns.TypeToString[qtype]] = &mdns.Msg{
+		MsgHdr: mdns.MsgHdr{Rcode: rcode},
+		Answer: answer,
+		Ns:     ns,
+	}
+}
+
+// dropQuestion 该查询的应答去掉问题部分，模拟畸形或恶意的上游
+func (f *fakeUpstream) dropQuestion(name string, qtype uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.noQuestion[strings.ToLower(name)+":"+mdns.TypeToString[qtype]] = true
+}
+
+func (f *fakeUpstream) serve(w mdns.ResponseWriter, r *mdns.Msg) {
+	q := r.Question[0]
+	key := strings.ToLower(q.Name) + ":" + mdns.TypeToString[q.Qtype]
+	f.mu.Lock()
+	tmpl := f.answers[key]
+	noQuestion := f.noQuestion[key]
+	f.mu.Unlock()
+
+	m := new(mdns.Msg)
+	if tmpl == nil {
+		m.SetRcode(r, mdns.RcodeRefused)
+		_ = w.WriteMsg(m)
+		return
+	}
+	m.SetRcode(r, tmpl.Rcode)
+	m.Answer = append(m.Answer, tmpl.Answer...)
+	m.Ns = append(m.Ns, tmpl.Ns...)
+	m.SetEdns0(4096, true)
+	if noQuestion {
+		m.Question = nil
+	}
+	_ = w.WriteMsg(m)
+}
+
+// newTestServer 创建只包含转发所需组件的服务器，不启动后台任务
+func newTestServer(cfg *Config) *Server {
+	s := &Server{
+		cfg:            cfg,
+		upstreamHealth: make(map[string]*healthState),
+		cache:          newDNSCache(cfg.GetMaxCacheEntries()),
+	}
+	s.latencyStats.routeStats = make(map[string]*routeLatencyStats)
+	s.ecs = newECSPolicies(cfg)
+	s.poison = newPoisonFilter(cfg)
+	s.dnssec = newDNSSECValidator(s, cfg)
+	return s
+}
+
+// dnssecFixture 根区、已签名的 example. 与未签名委派 insecure.
+type dnssecFixture struct {
+	s        *Server
+	upstream *fakeUpstream
+}
+
+func newDNSSECFixture(t *testing.T) *dnssecFixture {
+	t.Helper()
+	up := startFakeUpstream(t)
+	root := newTestZone(t, ".")
+	example := newTestZone(t, "example.")
+
+	rootSOA := mustRR(t, ". 3600 IN SOA a.root. admin.root. 1 7200 3600 1209600 3600")
+	exampleSOA := mustRR(t, "example. 3600 IN SOA ns.example. admin.example. 1 7200 3600 1209600 300")
+	insecureSOA := mustRR(t, "insecure. 3600 IN SOA ns.insecure. admin.insecure. 1 7200 3600 1209600 300")
+
+	// 根区
+	up.set(".", mdns.TypeDNSKEY, mdns.RcodeSuccess, root.signed(t, root.key), nil)
+	up.set(".", mdns.TypeSOA, mdns.RcodeSuccess, root.signed(t, rootSOA), nil)
+
+	// example. 由根区的 DS 委派
+	ds := example.ds()
+	ds.Hdr.Ttl = 3600
+	up.set("example.", mdns.TypeDS, mdns.RcodeSuccess, root.signed(t, ds), nil)
+	up.set("example.", mdns.TypeDNSKEY, mdns.RcodeSuccess, example.signed(t, example.key), nil)
+	up.set("example.", mdns.TypeSOA, mdns.RcodeSuccess, example.signed(t, exampleSOA), nil)
+	up.set("www.example.", mdns.TypeA, mdns.RcodeSuccess,
+		example.signed(t, mustRR(t, "www.example. 300 IN A 192.0.2.1")), nil)
+
+	// 签名被篡改：签名后修改记录内容
+	bad := mustRR(t, "bad.example. 300 IN A 192.0.2.2")
+	badSig := example.sign(t, bad)
+	bad.(*mdns.A).A = net.ParseIP("192.0.2.99")
+	up.set("bad.example.", mdns.TypeA, mdns.RcodeSuccess, []mdns.RR{bad, badSig}, nil)
+
+	// nx.example. 不存在：NSEC 覆盖该名称与 *.example.
+	nsecApex := mustRR(t, "example. 300 IN NSEC www.example. NS SOA RRSIG NSEC DNSKEY")
+	nsecWWW := mustRR(t, "www.example. 300 IN NSEC example. A RRSIG NSEC")
+	nxProof := append(example.signed(t, exampleSOA), example.signed(t, nsecApex)...)
+	nxProof = append(nxProof, example.signed(t, nsecWWW)...)
+	up.set("nx.example.", mdns.TypeA, mdns.RcodeNameError, nil, nxProof)
+
+	// www.example. 没有 AAAA：名称的 NSEC 不含 AAAA
+	nodata := append(example.signed(t, exampleSOA), example.signed(t, nsecWWW)...)
+	up.set("www.example.", mdns.TypeAAAA, mdns.RcodeSuccess, nil, nodata)
+
+	// 仅有签名的 SOA、没有 NSEC 的否定应答
+	up.set("missing.example.", mdns.TypeA, mdns.RcodeNameError, nil, example.signed(t, exampleSOA))
+
+	// 重放 www.example. 的 NSEC 否认 replay.example.：该 NSEC 既不匹配也不覆盖 replay.example.
+	up.set("replay.example.", mdns.TypeA, mdns.RcodeSuccess, nil, nodata)
+
+	// NSEC3 链：example. 与 www.example. 两个名称的散列互为下一名称
+	nsec3 := func(owner, next string, types ...uint16) []mdns.RR {
+		rr := &mdns.NSEC3{
+			Hdr:        mdns.RR_Header{Name: strings.ToLower(mdns.HashName(owner, mdns.SHA1, 0, "")) + ".example.", Rrtype: mdns.TypeNSEC3, Class: mdns.ClassINET, Ttl: 300},
+			Hash:       mdns.SHA1,
+			HashLength: 20,
+			NextDomain: mdns.HashName(next, mdns.SHA1, 0, ""),
+			TypeBitMap: types,
+		}
+		return example.signed(t, rr)
+	}
+	chain := append(nsec3("example.", "www.example.", mdns.TypeNS, mdns.TypeSOA, mdns.TypeRRSIG, mdns.TypeDNSKEY, mdns.TypeNSEC3PARAM),
+		nsec3("www.example.", "example.", mdns.TypeA, mdns.TypeRRSIG)...)
+	up.set("nx3.example.", mdns.TypeA, mdns.RcodeNameError, nil, append(example.signed(t, exampleSOA), chain...))
+	up.set("www.example.", mdns.TypeMX, mdns.RcodeSuccess, nil, append(example.signed(t, exampleSOA), chain...))
+	// 名称存在 A 记录，NSEC3 不能否认 A
+	up.set("www.example.", mdns.TypeTXT, mdns.RcodeNameError, nil, append(example.signed(t, exampleSOA), chain...))
+
+	// insecure. 未签名：根区 NSEC 证明委派没有 DS
+	nsecInsecure := mustRR(t, "insecure. 3600 IN NSEC zzz. NS RRSIG NSEC")
+	up.set("insecure.", mdns.TypeDS, mdns.RcodeSuccess, nil,
+		append(root.signed(t, rootSOA), root.signed(t, nsecInsecure)...))
+	up.set("www.insecure.", mdns.TypeSOA, mdns.RcodeSuccess, nil, []mdns.RR{insecureSOA})
+	up.set("www.insecure.", mdns.TypeA, mdns.RcodeSuccess, []mdns.RR{mustRR(t, "www.insecure. 300 IN A 198.51.100.1")}, nil)
+
+	cfg := &Config{}
+	cfg.DNSSEC.Enabled = true
+	cfg.DNSSEC.Mode = DNSSECModeEnforce
+	cfg.DNSSEC.Routes = []string{"intl"}
+	cfg.DNSSEC.TrustAnchors = []string{root.ds().String()}
+	return &dnssecFixture{s: newTestServer(cfg), upstream: up}
+}
+
+// query 以携带 DO 位的客户端请求经 intl 路由转发
+func (f *dnssecFixture) query(name string, qtype uint16, cd bool) (*mdns.Msg, error) {
+	req := new(mdns.Msg)
+	req.SetQuestion(name, qtype)
+	req.SetEdns0(4096, true)
+	req.CheckingDisabled = cd
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, _, err := f.s.forwardRoute(ctx, req, netip.MustParseAddr("127.0.0.1"), defaultPolicy, []string{f.upstream.addr}, "intl")
+	return resp, err
+}
+
+func TestDNSSECValidation(t *testing.T) {
+	f := newDNSSECFixture(t)
+
+	cases := []struct {
+		name    string
+		qname   string
+		qtype   uint16
+		wantAD  bool
+		wantErr bool
+	}{
+		{"secure", "www.example.", mdns.TypeA, true, false},
+		{"bogus signature", "bad.example.", mdns.TypeA, false, true},
+		{"insecure delegation", "www.insecure.", mdns.TypeA, false, false},
+		{"nxdomain proof", "nx.example.", mdns.TypeA, true, false},
+		{"nodata proof", "www.example.", mdns.TypeAAAA, true, false},
+		{"negative without proof", "missing.example.", mdns.TypeA, false, true},
+		{"nsec proves other name", "replay.example.", mdns.TypeA, false, true},
+		{"nsec3 nxdomain proof", "nx3.example.", mdns.TypeA, true, false},
+		{"nsec3 nodata proof", "www.example.", mdns.TypeMX, true, false},
+		{"nsec3 nxdomain for existing name", "www.example.", mdns.TypeTXT, false, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := f.query(c.qname, c.qtype, false)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("期望校验失败，实际应答:\n%v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.AuthenticatedData != c.wantAD {
+				t.Errorf("AD = %v, want %v", resp.AuthenticatedData, c.wantAD)
+			}
+		})
+	}
+}
+
+func TestDNSSECReplyWithoutQuestion(t *testing.T) {
+	f := newDNSSECFixture(t)
+
+	// 上游应答没有问题部分时不能导致处理协程 panic，否定应答缺少证明仍为 bogus
+	f.upstream.set("noq.example.", mdns.TypeA, mdns.RcodeNameError, nil, nil)
+	f.upstream.dropQuestion("noq.example.", mdns.TypeA)
+	resp, err := f.query("noq.example.", mdns.TypeA, false)
+	if err == nil {
+		t.Fatalf("期望校验失败，实际应答:\n%v", resp)
+	}
+
+	// 已签名的肯定应答按请求的问题校验
+	f.upstream.dropQuestion("www.example.", mdns.TypeA)
+	resp, err = f.query("www.example.", mdns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.AuthenticatedData {
+		t.Error("AD = false, want true")
+	}
+}
+
+func TestDNSSECCheckingDisabled(t *testing.T) {
+	f := newDNSSECFixture(t)
+
+	// 客户端设置 CD 位时不做校验，签名无效的应答原样返回
+	resp, err := f.query("bad.example.", mdns.TypeA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.CheckingDisabled || resp.AuthenticatedData {
+		t.Errorf("CD = %v, AD = %v, want CD set and AD clear", resp.CheckingDisabled, resp.AuthenticatedData)
+	}
+	if len(resp.Answer) == 0 {
+		t.Error("CD 请求应返回上游应答")
+	}
+}
+
+func TestCompareCanonical(t *testing.T) {
+	// RFC 4034 6.1 中的排序示例
+	ordered := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.",
+		"zABC.a.EXAMPLE.", "z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example.",
+	}
+	for i := 1; i < len(ordered); i++ {
+		if compareCanonical(ordered[i-1], ordered[i]) >= 0 {
+			t.Errorf("compareCanonical(%q, %q) >= 0", ordered[i-1], ordered[i])
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// dohMediaType RFC 8484 定义的 DNS 报文媒体类型
+const dohMediaType = "application/dns-message"
+
+// dohClient DNS over HTTPS (RFC 8484) 客户端，所有 DoH 上游共享连接池
+type dohClient struct {
+	client *http.Client
+}
+
+// newDoHClient 创建 DoH 客户端，启用 HTTP/2 与长连接复用
+func newDoHClient() *dohClient {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultUpstreamTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 16,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: defaultUpstreamTimeout,
+	}
+	return &dohClient{client: &http.Client{Transport: transport}}
+}
+
+// exchange 通过 DoH 发送查询，POST 或 GET 由上游地址决定
+func (d *dohClient) exchange(ctx context.Context, req *mdns.Msg, u upstream) (*mdns.Msg, error) {
+	// RFC 8484 建议 ID 置 0 以便 HTTP 缓存
+	msg := req.Copy()
+	msg.Id = 0
+	wire, err := msg.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("打包 DNS 报文失败: %v", err)
+	}
+
+	var httpReq *http.Request
+	if u.UseGET {
+		sep := "?"
+		if strings.Contains(u.Endpoint, "?") {
+			sep = "&"
+		}
+		target := u.Endpoint + sep + "dns=" + base64.RawURLEncoding.EncodeToString(wire)
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	} else {
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodPost, u.Endpoint, bytes.NewReader(wire))
+		if err == nil {
+			httpReq.Header.Set("Content-Type", dohMediaType)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建 DoH 请求失败: %v", err)
+	}
+	httpReq.Header.Set("Accept", dohMediaType)
+
+	httpResp, err := d.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH 上游返回 HTTP %d", httpResp.StatusCode)
+	}
+	if ct := httpResp.Header.Get("Content-Type"); !strings.HasPrefix(ct, dohMediaType) {
+		return nil, fmt.Errorf("DoH 上游返回未知内容类型: %q", ct)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, mdns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("读取 DoH 响应失败: %v", err)
+	}
+
+	resp := new(mdns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("解析 DoH 响应失败: %v", err)
+	}
+	resp.Id = req.Id
+	return resp, nil
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+// dohRequest 记录 DoH 服务器收到的请求
+type dohRequest struct {
+	method      string
+	contentType string
+	id          uint16
+}
+
+// startDoHServer 启动 DoH 测试服务器，handler 为 nil 时按 RFC 8484 应答 answerA
+func startDoHServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, chan dohRequest) {
+	t.Helper()
+	seen := make(chan dohRequest, 16)
+	if handler == nil {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			var wire []byte
+			var err error
+			if r.Method == http.MethodGet {
+				wire, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			} else {
+				wire, err = io.ReadAll(r.Body)
+			}
+			req := new(mdns.Msg)
+			if err == nil {
+				err = req.Unpack(wire)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			seen <- dohRequest{method: r.Method, contentType: r.Header.Get("Content-Type"), id: req.Id}
+
+			rw := &recordWriter{}
+			answerA(rw, req)
+			out, _ := rw.msgs[0].Pack()
+			w.Header().Set("Content-Type", dohMediaType)
+			_, _ = w.Write(out)
+		}
+	}
+	srv := httptest.NewTLSServer(handler)
+	t.Cleanup(srv.Close)
+	return srv, seen
+}
+
+func TestDoHExchange(t *testing.T) {
+	srv, seen := startDoHServer(t, nil)
+	d := &dohClient{client: srv.Client()}
+
+	cases := []struct {
+		name    string
+		address string
+		method  string
+	}{
+		{"post", srv.URL + "/dns-query", http.MethodPost},
+		{"get", srv.URL + "/dns-query{?dns}", http.MethodGet},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			u := parseUpstream(c.address)
+			if u.Proto != protoHTTPS {
+				t.Fatalf("proto = %s, want https", u.Proto)
+			}
+			req := testQuery("www.example.com.")
+			req.Id = 4242
+			resp, err := d.exchange(context.Background(), req, u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := <-seen
+			if got.method != c.method {
+				t.Errorf("method = %s, want %s", got.method, c.method)
+			}
+			if c.method == http.MethodPost && got.contentType != dohMediaType {
+				t.Errorf("content type = %q, want %q", got.contentType, dohMediaType)
+			}
+			// 发往上游的 ID 为 0，应答恢复为原 ID
+			if got.id != 0 {
+				t.Errorf("upstream saw id %d, want 0", got.id)
+			}
+			if resp.Id != req.Id {
+				t.Errorf("reply id = %d, want %d", resp.Id, req.Id)
+			}
+			if len(resp.Answer) != 1 {
+				t.Errorf("answer = %v", resp.Answer)
+			}
+		})
+	}
+}
+
+func TestDoHExchangeErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"http status", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}, "HTTP 503"},
+		{"content type", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		}, "内容类型"},
+		{"malformed body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", dohMediaType)
+			_, _ = w.Write([]byte{0x01})
+		}, "解析 DoH 响应失败"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv, _ := startDoHServer(t, c.handler)
+			d := &dohClient{client: srv.Client()}
+			_, err := d.exchange(context.Background(), testQuery("www.example.com."), parseUpstream(srv.URL+"/dns-query"))
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("err = %v, want %q", err, c.want)
+			}
+		})
+	}
+}
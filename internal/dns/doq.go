@@ -0,0 +1,176 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	mdns "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/sync/singleflight"
+)
+
+// doqALPN RFC 9250 规定的 ALPN 标识
+const doqALPN = "doq"
+
+// DoQ 应用层错误码 (RFC 9250 4.3)
+const (
+	doqNoError       quic.ApplicationErrorCode = 0x0
+	doqInternalError quic.ApplicationErrorCode = 0x1
+)
+
+// doqPool DNS over QUIC (RFC 9250) 连接池，每个上游复用一条 QUIC 连接，每个查询独占一个流
+type doqPool struct {
+	mu       sync.Mutex
+	conns    map[string]quic.EarlyConnection
+	sessions tls.ClientSessionCache // 会话票据缓存，用于 0-RTT 恢复
+	dials    singleflight.Group     // 同一上游的并发拨号合并为一次，拨号期间不持有 mu
+}
+
+// newDoQPool 创建 DoQ 连接池
+func newDoQPool() *doqPool {
+	return &doqPool{
+		conns:    make(map[string]quic.EarlyConnection),
+		sessions: tls.NewLRUClientSessionCache(64),
+	}
+}
+
+// exchange 通过 DoQ 发送查询，连接失效时重新建立一次
+func (p *doqPool) exchange(ctx context.Context, req *mdns.Msg, u upstream) (*mdns.Msg, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err := p.get(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := doqExchange(ctx, conn, req)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		// 连接可能已被服务端关闭，丢弃后重连
+		p.drop(u, conn)
+	}
+	return nil, lastErr
+}
+
+// get 获取上游的可用连接，不存在或已关闭时重新拨号。
+// 拨号在锁外进行，慢速或不可达的上游不会阻塞其他上游
+func (p *doqPool) get(ctx context.Context, u upstream) (quic.EarlyConnection, error) {
+	p.mu.Lock()
+	conn := p.conns[u.Addr]
+	p.mu.Unlock()
+	if conn != nil {
+		select {
+		case <-conn.Context().Done():
+		default:
+			return conn, nil
+		}
+	}
+
+	ch := p.dials.DoChan(u.Addr, func() (interface{}, error) {
+		tlsCfg := dotTLSConfig(u)
+		tlsCfg.NextProtos = []string{doqALPN}
+		tlsCfg.MinVersion = tls.VersionTLS13
+		tlsCfg.ClientSessionCache = p.sessions
+
+		// DialAddrEarly 在持有会话票据时使用 0-RTT 发送首个查询；
+		// 拨号由多个等待者共享，不受单个请求取消的影响，由握手超时限制
+		conn, err := quic.DialAddrEarly(context.WithoutCancel(ctx), u.Endpoint, tlsCfg, &quic.Config{
+			HandshakeIdleTimeout: defaultUpstreamTimeout,
+			MaxIdleTimeout:       dotIdleTimeout,
+			KeepAlivePeriod:      dotIdleTimeout / 2,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("DoQ 连接 %s 失败: %v", u.Endpoint, err)
+		}
+		p.mu.Lock()
+		p.conns[u.Addr] = conn
+		p.mu.Unlock()
+		return conn, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(quic.EarlyConnection), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// drop 关闭并移除失效连接
+func (p *doqPool) drop(u upstream, conn quic.EarlyConnection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[u.Addr] == conn {
+		delete(p.conns, u.Addr)
+	}
+	_ = conn.CloseWithError(doqNoError, "")
+}
+
+// doqExchange 在新的双向流上完成一次查询
+func doqExchange(ctx context.Context, conn quic.Connection, req *mdns.Msg) (*mdns.Msg, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	// RFC 9250 4.2.1: 报文 ID 必须为 0
+	msg := req.Copy()
+	msg.Id = 0
+	if err := writeDoQMsg(stream, msg); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqInternalError))
+		return nil, err
+	}
+	// 发送完查询后关闭写方向，表示查询结束
+	_ = stream.Close()
+
+	resp, err := readDoQMsg(stream)
+	if err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqInternalError))
+		return nil, err
+	}
+	resp.Id = req.Id
+	return resp, nil
+}
+
+// writeDoQMsg 写入带 2 字节长度前缀的 DNS 报文
+func writeDoQMsg(w io.Writer, msg *mdns.Msg) error {
+	wire, err := msg.Pack()
+	if err != nil {
+		return fmt.Errorf("打包 DNS 报文失败: %v", err)
+	}
+	buf := make([]byte, 2+len(wire))
+	binary.BigEndian.PutUint16(buf, uint16(len(wire)))
+	copy(buf[2:], wire)
+	_, err = w.Write(buf)
+	return err
+}
+
+// readDoQMsg 读取带 2 字节长度前缀的 DNS 报文
+func readDoQMsg(r io.Reader) (*mdns.Msg, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	wire := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, wire); err != nil {
+		return nil, err
+	}
+	msg := new(mdns.Msg)
+	if err := msg.Unpack(wire); err != nil {
+		return nil, fmt.Errorf("解析 DoQ 报文失败: %v", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+)
+
+// doqServer DoQ 测试服务器，记录建立的连接与收到的报文 ID
+type doqServer struct {
+	addr  string
+	conns atomic.Int64
+	ids   chan uint16
+
+	mu   sync.Mutex
+	live []quic.Connection
+}
+
+// startDoQServer 启动 DoQ 测试服务器，每个流按 RFC 9250 读取一个查询并应答 answerA
+func startDoQServer(t *testing.T) *doqServer {
+	t.Helper()
+	cert := newTestCert(t)
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{doqALPN},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	d := &doqServer{addr: ln.Addr().String(), ids: make(chan uint16, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			d.conns.Add(1)
+			d.mu.Lock()
+			d.live = append(d.live, conn)
+			d.mu.Unlock()
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *doqServer) serve(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			req, err := readDoQMsg(stream)
+			if err != nil {
+				return
+			}
+			d.ids <- req.Id
+			rw := &recordWriter{}
+			answerA(rw, req)
+			_ = writeDoQMsg(stream, rw.msgs[0])
+		}()
+	}
+}
+
+// closeAll 由服务端关闭全部连接，模拟空闲超时或重启
+func (d *doqServer) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range d.live {
+		_ = conn.CloseWithError(doqNoError, "")
+	}
+	d.live = nil
+}
+
+func TestDoQExchange(t *testing.T) {
+	srv := startDoQServer(t)
+	u := parseUpstream("quic://" + srv.addr)
+	if u.Proto != protoQUIC {
+		t.Fatalf("proto = %s, want quic", u.Proto)
+	}
+	p := newDoQPool()
+
+	for i := 0; i < 3; i++ {
+		req := testQuery("www.example.com.")
+		req.Id = uint16(1000 + i)
+		resp, err := p.exchange(context.Background(), req, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// RFC 9250：线上报文 ID 为 0，应答恢复为原 ID
+		if id := <-srv.ids; id != 0 {
+			t.Errorf("server saw id %d, want 0", id)
+		}
+		if resp.Id != req.Id || len(resp.Answer) != 1 {
+			t.Errorf("reply id = %d answer = %v, want id %d with one answer", resp.Id, resp.Answer, req.Id)
+		}
+	}
+	// 多个查询复用同一条连接，每个查询一个流
+	if n := srv.conns.Load(); n != 1 {
+		t.Errorf("server accepted %d connections, want 1", n)
+	}
+}
+
+func TestDoQReconnect(t *testing.T) {
+	srv := startDoQServer(t)
+	u := parseUpstream("quic://" + srv.addr)
+	p := newDoQPool()
+
+	if _, err := p.exchange(context.Background(), testQuery("www.example.com."), u); err != nil {
+		t.Fatal(err)
+	}
+	<-srv.ids
+
+	// 服务端关闭连接后，下一个查询重新拨号并成功
+	srv.closeAll()
+	if _, err := p.exchange(context.Background(), testQuery("www.example.com."), u); err != nil {
+		t.Fatalf("exchange after server close: %v", err)
+	}
+	<-srv.ids
+	if n := srv.conns.Load(); n != 2 {
+		t.Errorf("server accepted %d connections, want 2", n)
+	}
+}
+
+func TestDoQRejectsWrongALPN(t *testing.T) {
+	cert := newTestCert(t)
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h3"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	p := newDoQPool()
+	u := parseUpstream("quic://" + ln.Addr().String())
+	if _, err := p.exchange(context.Background(), testQuery("www.example.com."), u); err == nil {
+		t.Error("exchange with non-DoQ ALPN succeeded")
+	}
+}
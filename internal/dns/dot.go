@@ -0,0 +1,287 @@
+package dns
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+// dotIdleTimeout 流水线连接空闲多久后主动关闭
+const dotIdleTimeout = 30 * time.Second
+
+var errPipeClosed = errors.New("dns 流水线连接已关闭")
+
+// upstreamRootCAs 校验 DoT / DoQ 上游证书的根证书，nil 时使用系统根证书
+var upstreamRootCAs *x509.CertPool
+
+// dotPool DNS over TLS (RFC 7858) 连接池，每个上游维护一条可流水线复用的连接
+type dotPool struct {
+	mu    sync.Mutex
+	conns map[string]*pipeConn
+	dials singleflight.Group // 同一上游的并发拨号合并为一次，拨号期间不持有 mu
+
+	sessions tls.ClientSessionCache // 会话票据缓存（按服务器名区分），重连时恢复 TLS 会话
+}
+
+// newDoTPool 创建 DoT 连接池
+func newDoTPool() *dotPool {
+	return &dotPool{
+		conns:    make(map[string]*pipeConn),
+		sessions: tls.NewLRUClientSessionCache(64),
+	}
+}
+
+// exchange 通过 DoT 发送查询，连接失效时重新建立一次
+func (p *dotPool) exchange(ctx context.Context, req *mdns.Msg, u upstream) (*mdns.Msg, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		pc, err := p.get(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := pc.exchange(ctx, req)
+		if errors.Is(err, errPipeClosed) {
+			// 服务端已关闭空闲连接，重连后重试
+			continue
+		}
+		return resp, err
+	}
+	return nil, errPipeClosed
+}
+
+// get 获取上游的可用连接，不存在或已关闭时重新拨号。
+// 拨号在锁外进行，慢速或不可达的上游不会阻塞其他上游
+func (p *dotPool) get(ctx context.Context, u upstream) (*pipeConn, error) {
+	p.mu.Lock()
+	pc := p.conns[u.Addr]
+	p.mu.Unlock()
+	if pc != nil && !pc.isClosed() {
+		return pc, nil
+	}
+
+	ch := p.dials.DoChan(u.Addr, func() (interface{}, error) {
+		tlsCfg := dotTLSConfig(u)
+		tlsCfg.ClientSessionCache = p.sessions
+		dialer := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: defaultUpstreamTimeout, KeepAlive: 30 * time.Second},
+			Config:    tlsCfg,
+		}
+		// 拨号由多个等待者共享，不受单个请求取消的影响
+		conn, err := dialer.DialContext(context.WithoutCancel(ctx), "tcp", u.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("DoT 连接 %s 失败: %v", u.Endpoint, err)
+		}
+		pc := newPipeConn(conn)
+		p.mu.Lock()
+		p.conns[u.Addr] = pc
+		p.mu.Unlock()
+		return pc, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*pipeConn), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dotTLSConfig 构造 DoT 的 TLS 配置：始终校验证书与主机名，配置了 SPKI 指纹时额外校验公钥
+func dotTLSConfig(u upstream) *tls.Config {
+	cfg := &tls.Config{
+		ServerName: u.ServerName,
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    upstreamRootCAs,
+	}
+	if len(u.SPKIPins) > 0 {
+		pins := u.SPKIPins
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifySPKIPins(cs.PeerCertificates, pins)
+		}
+	}
+	return cfg
+}
+
+// verifySPKIPins 校验证书链中是否存在与指纹匹配的公钥
+func verifySPKIPins(certs []*x509.Certificate, pins []string) error {
+	for _, cert := range certs {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		fingerprint := base64.StdEncoding.EncodeToString(sum[:])
+		for _, pin := range pins {
+			if pin == fingerprint {
+				return nil
+			}
+		}
+	}
+	return errors.New("证书公钥与配置的 SPKI 指纹不匹配")
+}
+
+// pipeConn 流式 DNS 连接，支持多个查询同时在途（按报文 ID 分发响应）
+type pipeConn struct {
+	conn *mdns.Conn
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	pending  map[uint16]*pendingQuery
+	nextID   uint16
+	closed   bool
+	lastUsed time.Time
+}
+
+// pendingQuery 在途查询，响应需与请求的问题一致才会交付
+type pendingQuery struct {
+	question mdns.Question
+	ch       chan *mdns.Msg
+}
+
+// matches 判断响应的问题是否与请求一致
+func (q *pendingQuery) matches(msg *mdns.Msg) bool {
+	if len(msg.Question) != 1 {
+		return false
+	}
+	got := msg.Question[0]
+	return got.Qtype == q.question.Qtype && got.Qclass == q.question.Qclass &&
+		strings.EqualFold(got.Name, q.question.Name)
+}
+
+// newPipeConn 包装已建立的流式连接并启动读循环
+func newPipeConn(conn net.Conn) *pipeConn {
+	pc := &pipeConn{
+		conn:     &mdns.Conn{Conn: conn},
+		pending:  make(map[uint16]*pendingQuery),
+		nextID:   uint16(rand.Intn(1 << 16)),
+		lastUsed: time.Now(),
+	}
+	go pc.readLoop()
+	go pc.idleWatch()
+	return pc
+}
+
+func (pc *pipeConn) isClosed() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.closed
+}
+
+// exchange 在连接上发送一次查询并等待对应 ID 的响应
+func (pc *pipeConn) exchange(ctx context.Context, req *mdns.Msg) (*mdns.Msg, error) {
+	if len(req.Question) != 1 {
+		return nil, fmt.Errorf("请求必须包含一个问题")
+	}
+	ch := make(chan *mdns.Msg, 1)
+
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return nil, errPipeClosed
+	}
+	// 分配连接内唯一的报文 ID，避免不同客户端的相同 ID 冲突
+	id := pc.nextID
+	for {
+		if _, used := pc.pending[id]; !used {
+			break
+		}
+		id++
+	}
+	pc.nextID = id + 1
+	pc.pending[id] = &pendingQuery{question: req.Question[0], ch: ch}
+	pc.lastUsed = time.Now()
+	pc.mu.Unlock()
+
+	defer func() {
+		pc.mu.Lock()
+		delete(pc.pending, id)
+		pc.mu.Unlock()
+	}()
+
+	msg := req.Copy()
+	msg.Id = id
+
+	pc.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = pc.conn.SetWriteDeadline(deadline)
+	}
+	err := pc.conn.WriteMsg(msg)
+	pc.writeMu.Unlock()
+	if err != nil {
+		pc.close()
+		return nil, errPipeClosed
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errPipeClosed
+		}
+		resp.Id = req.Id
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop 持续读取响应并分发给等待者
+func (pc *pipeConn) readLoop() {
+	for {
+		msg, err := pc.conn.ReadMsg()
+		if err != nil {
+			pc.close()
+			return
+		}
+		pc.mu.Lock()
+		// ID 相同但问题不一致的响应视为伪造或错配，丢弃并继续等待
+		if q, ok := pc.pending[msg.Id]; ok && q.matches(msg) {
+			q.ch <- msg
+			delete(pc.pending, msg.Id)
+		}
+		pc.mu.Unlock()
+	}
+}
+
+// idleWatch 连接空闲超时后关闭，避免长期占用上游资源
+func (pc *pipeConn) idleWatch() {
+	ticker := time.NewTicker(dotIdleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		pc.mu.Lock()
+		idle := len(pc.pending) == 0 && time.Since(pc.lastUsed) > dotIdleTimeout
+		closed := pc.closed
+		pc.mu.Unlock()
+		if closed {
+			return
+		}
+		if idle {
+			pc.close()
+			return
+		}
+	}
+}
+
+// close 关闭连接并唤醒所有等待者
+func (pc *pipeConn) close() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return
+	}
+	pc.closed = true
+	_ = pc.conn.Close()
+	for id, q := range pc.pending {
+		close(q.ch)
+		delete(pc.pending, id)
+	}
+}
@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// newTestCert 生成 127.0.0.1 的自签名证书，并在测试期间将其设为上游根证书
+func newTestCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "boomdns test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	prev := upstreamRootCAs
+	upstreamRootCAs = pool
+	t.Cleanup(func() { upstreamRootCAs = prev })
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+// answerA 对任意 A 查询应答 192.0.2.1
+func answerA(w mdns.ResponseWriter, r *mdns.Msg) {
+	m := new(mdns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &mdns.A{
+		Hdr: mdns.RR_Header{Name: r.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	_ = w.WriteMsg(m)
+}
+
+// startDoTServer 启动 DoT 测试服务器，返回 tls:// 上游地址
+func startDoTServer(t *testing.T) string {
+	t.Helper()
+	cert := newTestCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{Listener: ln, Net: "tcp-tls", Handler: mdns.HandlerFunc(answerA)}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return "tls://" + ln.Addr().String()
+}
+
+func TestDoTSessionResumption(t *testing.T) {
+	u := parseUpstream(startDoTServer(t))
+	p := newDoTPool()
+	req := new(mdns.Msg)
+	req.SetQuestion("example.com.", mdns.TypeA)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		resp, err := p.exchange(ctx, req, u)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Answer) != 1 || resp.Id != req.Id {
+			t.Fatalf("resp = %v", resp)
+		}
+
+		p.mu.Lock()
+		pc := p.conns[u.Addr]
+		p.mu.Unlock()
+		state := pc.conn.Conn.(*tls.Conn).ConnectionState()
+		if resumed := i > 0; state.DidResume != resumed {
+			t.Errorf("connection %d DidResume = %v, want %v", i, state.DidResume, resumed)
+		}
+		// 关闭连接，下一次查询重新拨号
+		pc.close()
+	}
+}
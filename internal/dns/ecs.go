@@ -0,0 +1,229 @@
+package dns
+
+import (
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ECS 模式
+const (
+	ECSModePassthrough = "passthrough" // 原样转发客户端携带的 ECS（默认）
+	ECSModeStrip       = "strip"       // 去掉 ECS，不向上游暴露客户端网段
+	ECSModeSubnet      = "subnet"      // 发送配置的固定子网
+	ECSModeClient      = "client"      // 发送客户端真实地址截断后的子网，内网客户端使用配置的子网
+)
+
+// ECSPolicy 路由的 EDNS Client Subnet 策略 (RFC 7871)
+type ECSPolicy struct {
+	Mode       string `yaml:"mode"`        // passthrough / strip / subnet / client
+	Subnet     string `yaml:"subnet"`      // subnet 模式发送的子网，如 "203.0.113.0/24"；client 模式下内网客户端使用
+	IPv4Prefix int    `yaml:"ipv4_prefix"` // client 模式 IPv4 截断长度，默认 24
+	IPv6Prefix int    `yaml:"ipv6_prefix"` // client 模式 IPv6 截断长度，默认 56
+}
+
+// ecsPolicy 编译后的 ECS 策略
+type ecsPolicy struct {
+	mode   string
+	subnet netip.Prefix // 无效时为零值
+	v4Bits int
+	v6Bits int
+}
+
+// newECSPolicies 按路由（上游组）编译 ECS 策略，未配置的路由为 passthrough
+func newECSPolicies(cfg *Config) map[string]*ecsPolicy {
+	policies := make(map[string]*ecsPolicy, len(cfg.ECS))
+	for route, p := range cfg.ECS {
+		mode := strings.ToLower(strings.TrimSpace(p.Mode))
+		switch mode {
+		case "", ECSModePassthrough:
+			continue
+		case ECSModeStrip, ECSModeSubnet, ECSModeClient:
+		default:
+			log.Printf("忽略未知的 ECS 模式 %s: %s", route, p.Mode)
+			continue
+		}
+		ep := &ecsPolicy{mode: mode, v4Bits: 24, v6Bits: 56}
+		if p.IPv4Prefix > 0 && p.IPv4Prefix <= 32 {
+			ep.v4Bits = p.IPv4Prefix
+		}
+		if p.IPv6Prefix > 0 && p.IPv6Prefix <= 128 {
+			ep.v6Bits = p.IPv6Prefix
+		}
+		if p.Subnet != "" {
+			prefix, err := parseClientPrefix(p.Subnet)
+			if err != nil {
+				log.Printf("ECS 子网无效 %s: %v", route, err)
+			} else {
+				ep.subnet = prefix
+			}
+		}
+		if mode == ECSModeSubnet && !ep.subnet.IsValid() {
+			log.Printf("ECS subnet 模式未配置有效子网，%s 按 strip 处理", route)
+			ep.mode = ECSModeStrip
+		}
+		policies[strings.ToLower(route)] = ep
+	}
+	return policies
+}
+
+// subnetFor 返回该策略下发往上游的子网，ok 为 false 表示不发送 ECS
+func (p *ecsPolicy) subnetFor(client netip.Addr) (netip.Prefix, bool) {
+	switch p.mode {
+	case ECSModeSubnet:
+		return p.subnet, true
+	case ECSModeClient:
+		if client.IsValid() && isPublicAddr(client) {
+			bits := p.v6Bits
+			if client.Is4() {
+				bits = p.v4Bits
+			}
+			if prefix, err := client.Prefix(bits); err == nil {
+				return prefix, true
+			}
+		}
+		return p.subnet, p.subnet.IsValid()
+	}
+	return netip.Prefix{}, false
+}
+
+// sharedAddressSpace 运营商级 NAT 地址段 (RFC 6598)
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr 判断是否为公网地址
+func isPublicAddr(ip netip.Addr) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
+
+// ecsScope 发往上游的 ECS 对缓存键的影响：收集各路由实际转发的子网。
+// 未配置策略（passthrough）的路由转发客户端携带的 ECS，client 模式的路由按客户端子网区分；
+// strip 与 subnet 模式发往上游的内容与客户端无关，不影响缓存键
+func (s *Server) ecsScope(req *mdns.Msg, client netip.Addr, routes []string) string {
+	var scopes []string
+	for _, route := range routes {
+		p := s.ecs[upstreamGroupOf(route)]
+		switch {
+		case p == nil:
+			if opt := req.IsEdns0(); opt != nil {
+				if e := ecsOption(opt); e != nil {
+					scopes = append(scopes, ecsPrefix(e).String())
+				}
+			}
+		case p.mode == ECSModeClient:
+			if prefix, ok := p.subnetFor(client); ok {
+				scopes = append(scopes, prefix.String())
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		return ""
+	}
+	sort.Strings(scopes)
+	return strings.Join(slices.Compact(scopes), ",")
+}
+
+// withECS 按路由的 ECS 策略生成发往上游的请求，需要修改时返回副本
+func (s *Server) withECS(req *mdns.Msg, target string, client netip.Addr) *mdns.Msg {
+	p := s.ecs[upstreamGroupOf(target)]
+	if p == nil {
+		return req
+	}
+	prefix, send := p.subnetFor(client)
+	opt := req.IsEdns0()
+	if !send && (opt == nil || ecsOption(opt) == nil) {
+		return req
+	}
+
+	out := req.Copy()
+	opt = out.IsEdns0()
+	if opt == nil {
+		// 客户端未携带 EDNS，添加 OPT 以携带 ECS
+		out.SetEdns0(mdns.DefaultMsgSize, false)
+		opt = out.IsEdns0()
+	}
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != mdns.EDNS0SUBNET {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+	if send {
+		opt.Option = append(opt.Option, newECSOption(prefix))
+	}
+	return out
+}
+
+// newECSOption 由子网构造 ECS 选项
+func newECSOption(prefix netip.Prefix) *mdns.EDNS0_SUBNET {
+	e := &mdns.EDNS0_SUBNET{Code: mdns.EDNS0SUBNET, SourceNetmask: uint8(prefix.Bits())}
+	if prefix.Addr().Is4() {
+		e.Family = 1
+		e.Address = net.IP(prefix.Addr().AsSlice()).To4()
+	} else {
+		e.Family = 2
+		e.Address = net.IP(prefix.Addr().AsSlice())
+	}
+	return e
+}
+
+// restoreECS 将上游应答中的 ECS 恢复为客户端视角：客户端未携带 ECS 时去掉该选项，
+// 未携带 EDNS 时去掉 OPT；客户端携带的 ECS 被替换时回显原选项，作用域置 0
+func restoreECS(resp, orig *mdns.Msg) {
+	if resp == nil {
+		return
+	}
+	respOpt := resp.IsEdns0()
+	if respOpt == nil {
+		return
+	}
+	origOpt := orig.IsEdns0()
+	if origOpt == nil {
+		extra := resp.Extra[:0]
+		for _, rr := range resp.Extra {
+			if rr.Header().Rrtype != mdns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		resp.Extra = extra
+		return
+	}
+	options := respOpt.Option[:0]
+	for _, o := range respOpt.Option {
+		if o.Option() != mdns.EDNS0SUBNET {
+			options = append(options, o)
+		}
+	}
+	respOpt.Option = options
+	if e := ecsOption(origOpt); e != nil {
+		echo := *e
+		echo.SourceScope = 0
+		respOpt.Option = append(respOpt.Option, &echo)
+	}
+}
+
+// matchECSEcho 缓存应答可能由携带其他 ECS 的客户端写入（ECS 不影响缓存键的路由共享同一条目），
+// 回显的 ECS 与当前请求不一致时按当前请求恢复
+func matchECSEcho(resp, req *mdns.Msg) {
+	respOpt := resp.IsEdns0()
+	if respOpt == nil {
+		return
+	}
+	var want, got netip.Prefix
+	if opt := req.IsEdns0(); opt != nil {
+		if e := ecsOption(opt); e != nil {
+			want = ecsPrefix(e)
+		}
+	}
+	if e := ecsOption(respOpt); e != nil {
+		got = ecsPrefix(e)
+	}
+	if want != got {
+		restoreECS(resp, req)
+	}
+}
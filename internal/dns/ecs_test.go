@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ecsUpstream 记录收到的 ECS 子网（未携带时为空），应答中回显该 ECS 并将作用域设为源前缀长度
+func ecsUpstream(t *testing.T) (string, chan string) {
+	t.Helper()
+	seen := make(chan string, 16)
+	addr := startTestUpstream(t, func(w mdns.ResponseWriter, r *mdns.Msg) {
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &mdns.A{
+			Hdr: mdns.RR_Header{Name: r.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		subnet := ""
+		if opt := r.IsEdns0(); opt != nil {
+			m.SetEdns0(opt.UDPSize(), false)
+			if e := ecsOption(opt); e != nil {
+				subnet = ecsPrefix(e).String()
+				echo := *e
+				echo.SourceScope = e.SourceNetmask
+				m.IsEdns0().Option = append(m.IsEdns0().Option, &echo)
+			}
+		}
+		seen <- subnet
+		_ = w.WriteMsg(m)
+	})
+	return addr, seen
+}
+
+// ecsQuery 构造查询，edns 为 false 时不带 OPT，subnet 非空时携带该 ECS
+func ecsQuery(edns bool, subnet string) *mdns.Msg {
+	req := testQuery("www.example.com.")
+	if edns {
+		req.SetEdns0(mdns.DefaultMsgSize, false)
+	}
+	if subnet != "" {
+		req.IsEdns0().Option = append(req.IsEdns0().Option, newECSOption(netip.MustParsePrefix(subnet)))
+	}
+	return req
+}
+
+// replyECS 返回客户端收到的应答中的 OPT 与 ECS 子网、作用域
+func replyECS(resp *mdns.Msg) (hasOPT bool, subnet string, scope uint8) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return false, "", 0
+	}
+	if e := ecsOption(opt); e != nil {
+		return true, ecsPrefix(e).String(), e.SourceScope
+	}
+	return true, "", 0
+}
+
+func TestECSForwarding(t *testing.T) {
+	cfg := &Config{}
+	cfg.ECS = map[string]ECSPolicy{
+		"intl":  {Mode: ECSModeStrip},
+		"china": {Mode: ECSModeSubnet, Subnet: "203.0.113.0/24"},
+		"local": {Mode: ECSModeClient, Subnet: "203.0.113.0/24", IPv6Prefix: 48},
+	}
+	s := newTestServer(cfg)
+	addr, seen := ecsUpstream(t)
+
+	cases := []struct {
+		name     string
+		target   string
+		client   string
+		req      *mdns.Msg
+		upstream string // 上游收到的 ECS
+		hasOPT   bool   // 客户端应答是否带 OPT
+		echo     string // 客户端应答中的 ECS
+	}{
+		{"strip echoes client ecs", "intl", "198.51.100.7", ecsQuery(true, "198.51.100.0/24"), "", true, "198.51.100.0/24"},
+		{"strip without ecs", "intl", "198.51.100.7", ecsQuery(true, ""), "", true, ""},
+		{"subnet without edns", "china", "198.51.100.7", ecsQuery(false, ""), "203.0.113.0/24", false, ""},
+		{"subnet replaces client ecs", "china", "198.51.100.7", ecsQuery(true, "198.51.100.0/24"), "203.0.113.0/24", true, "198.51.100.0/24"},
+		{"client ipv4", "local", "198.51.100.7", ecsQuery(true, ""), "198.51.100.0/24", true, ""},
+		{"client ipv6", "local", "2001:db8:1:2::1", ecsQuery(false, ""), "2001:db8:1::/48", false, ""},
+		{"private client uses subnet", "local", "192.168.1.5", ecsQuery(false, ""), "203.0.113.0/24", false, ""},
+		{"cgnat client uses subnet", "local", "100.64.1.5", ecsQuery(false, ""), "203.0.113.0/24", false, ""},
+		{"passthrough", "fallback", "198.51.100.7", ecsQuery(true, "198.51.100.0/24"), "198.51.100.0/24", true, "198.51.100.0/24"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			orig := c.req.Copy()
+			resp, _, err := s.forwardRoute(context.Background(), c.req, netip.MustParseAddr(c.client), defaultPolicy, []string{addr}, c.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := <-seen; got != c.upstream {
+				t.Errorf("upstream received ecs %q, want %q", got, c.upstream)
+			}
+			hasOPT, echo, scope := replyECS(resp)
+			if hasOPT != c.hasOPT || echo != c.echo {
+				t.Errorf("reply opt = %v ecs = %q, want opt = %v ecs = %q", hasOPT, echo, c.hasOPT, c.echo)
+			}
+			// 替换后的 ECS 按客户端原选项回显，作用域为 0；透传时保留上游的作用域
+			if c.echo != "" && c.target != "fallback" && scope != 0 {
+				t.Errorf("echoed scope = %d, want 0", scope)
+			}
+			// 不修改客户端的原请求
+			if c.req.String() != orig.String() {
+				t.Errorf("request modified:\n%s\nwant:\n%s", c.req, orig)
+			}
+		})
+	}
+}
+
+func TestECSScope(t *testing.T) {
+	cfg := &Config{}
+	cfg.ECS = map[string]ECSPolicy{
+		"intl":  {Mode: ECSModeStrip},
+		"china": {Mode: ECSModeClient, Subnet: "203.0.113.0/24"},
+	}
+	s := newTestServer(cfg)
+	public := netip.MustParseAddr("198.51.100.7")
+	private := netip.MustParseAddr("192.168.1.5")
+
+	cases := []struct {
+		name   string
+		req    *mdns.Msg
+		client netip.Addr
+		routes []string
+		want   string
+	}{
+		{"strip ignores client ecs", ecsQuery(true, "198.51.100.0/24"), public, []string{"intl"}, ""},
+		{"client mode", ecsQuery(false, ""), public, []string{"china", "intl"}, "198.51.100.0/24"},
+		{"client mode fallback route", ecsQuery(false, ""), public, []string{"china-fallback"}, "198.51.100.0/24"},
+		{"private client", ecsQuery(false, ""), private, []string{"china"}, "203.0.113.0/24"},
+		{"passthrough", ecsQuery(true, "192.0.2.0/24"), private, []string{"local"}, "192.0.2.0/24"},
+		{"passthrough without ecs", ecsQuery(true, ""), public, []string{"local"}, ""},
+		{"merged", ecsQuery(true, "192.0.2.0/24"), public, []string{"china", "local", "intl"}, "192.0.2.0/24,198.51.100.0/24"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := s.ecsScope(c.req, c.client, c.routes); got != c.want {
+				t.Errorf("ecsScope = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"strconv"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ForwardZone 条件转发区域：区域内（含子域名）的查询只发往指定上游
+type ForwardZone struct {
+	Zone      string   `yaml:"zone" json:"zone"`
+	Upstreams []string `yaml:"upstreams" json:"upstreams"`
+}
+
+// privateReverseZones 私有地址反向解析区域 (RFC 6303 / RFC 6761)，不得泄露到公网上游
+var privateReverseZones = func() []string {
+	zones := []string{
+		"0.in-addr.arpa",               // 0.0.0.0/8
+		"10.in-addr.arpa",              // 10.0.0.0/8
+		"127.in-addr.arpa",             // 127.0.0.0/8
+		"254.169.in-addr.arpa",         // 169.254.0.0/16
+		"168.192.in-addr.arpa",         // 192.168.0.0/16
+		"2.0.192.in-addr.arpa",         // 192.0.2.0/24 TEST-NET-1
+		"100.51.198.in-addr.arpa",      // 198.51.100.0/24 TEST-NET-2
+		"113.0.203.in-addr.arpa",       // 203.0.113.0/24 TEST-NET-3
+		"255.255.255.255.in-addr.arpa", // 广播地址
+		"0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa", // ::/128
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa", // ::1/128
+		"c.f.ip6.arpa",                                                         // fc00::/8
+		"d.f.ip6.arpa",                                                         // fd00::/8
+		"8.e.f.ip6.arpa", "9.e.f.ip6.arpa", "a.e.f.ip6.arpa", "b.e.f.ip6.arpa", // fe80::/10
+		"8.b.d.0.1.0.0.2.ip6.arpa", // 2001:db8::/32
+	}
+	for i := 16; i <= 31; i++ { // 172.16.0.0/12
+		zones = append(zones, strconv.Itoa(i)+".172.in-addr.arpa")
+	}
+	for i := 64; i <= 127; i++ { // 100.64.0.0/10
+		zones = append(zones, strconv.Itoa(i)+".100.in-addr.arpa")
+	}
+	return zones
+}()
+
+// zoneRouter 按最长后缀匹配条件转发区域
+type zoneRouter struct {
+	zones   map[string]*ForwardZone
+	private map[string]bool
+}
+
+// newZoneRouter 由配置构建区域路由
+func newZoneRouter(cfg *Config) *zoneRouter {
+	r := &zoneRouter{
+		zones:   make(map[string]*ForwardZone),
+		private: make(map[string]bool, len(privateReverseZones)),
+	}
+	for _, z := range cfg.ForwardZones {
+		name := strings.Trim(strings.ToLower(strings.TrimSpace(z.Zone)), ".")
+		if name == "" || len(z.Upstreams) == 0 {
+			continue
+		}
+		r.zones[name] = &ForwardZone{Zone: name, Upstreams: z.Upstreams}
+	}
+	for _, z := range privateReverseZones {
+		r.private[z] = true
+	}
+	return r
+}
+
+// match 返回域名所属的最具体的转发区域
+func (r *zoneRouter) match(name string) *ForwardZone {
+	if r == nil || len(r.zones) == 0 {
+		return nil
+	}
+	for suffix := name; ; {
+		if z := r.zones[suffix]; z != nil {
+			return z
+		}
+		i := strings.IndexByte(suffix, '.')
+		if i < 0 {
+			return nil
+		}
+		suffix = suffix[i+1:]
+	}
+}
+
+// isPrivatePTR 判断是否为私有地址的反向解析查询
+func (r *zoneRouter) isPrivatePTR(name string) bool {
+	if r == nil || !strings.HasSuffix(name, ".arpa") {
+		return false
+	}
+	for suffix := name; ; {
+		if r.private[suffix] {
+			return true
+		}
+		i := strings.IndexByte(suffix, '.')
+		if i < 0 {
+			return false
+		}
+		suffix = suffix[i+1:]
+	}
+}
+
+// privatePTRResponse 私有反向解析在本地返回 NXDOMAIN (RFC 6303)
+func privatePTRResponse(req *mdns.Msg) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetRcode(req, mdns.RcodeNameError)
+	m.Authoritative = true
+	m.RecursionAvailable = true
+	m.Ns = []mdns.RR{blockSOA(req.Question[0].Name, defaultBlockTTL)}
+	return m
+}
@@ -0,0 +1,328 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 熔断默认参数：连续失败 N 次后熔断 M 时间，到期后进入半开状态发送探测查询
+const (
+	defaultCircuitFailThreshold = 3
+	defaultCircuitOpenDuration  = 30 * time.Second
+)
+
+// 熔断器状态
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// latencySampleSize 每个上游保留的最近延迟样本数，用于计算分位数
+const latencySampleSize = 256
+
+var upstreamCircuitProbes = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "boomdns_upstream_circuit_probes_total",
+		Help: "Half-open health probes sent to tripped upstreams",
+	},
+	[]string{"target", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(upstreamCircuitProbes)
+}
+
+// healthState 单个上游的健康状态
+type healthState struct {
+	failures      int       // 连续失败次数
+	totalFailures int64     // 累计失败次数
+	successes     int64     // 累计成功次数
+	trippedUntil  time.Time // 熔断截止时间，零值表示闭合
+	probing       bool      // 正在进行半开探测
+	lastErr       string
+	lastErrAt     time.Time
+
+	ewma    time.Duration // 延迟指数加权移动平均，供 fastest 策略排序
+	samples [latencySampleSize]time.Duration
+	count   int // 已记录的样本总数
+}
+
+// state 返回熔断器状态：熔断期内为 open，到期后等待探测成功前为 half-open
+func (st *healthState) state(now time.Time) string {
+	switch {
+	case st.trippedUntil.IsZero():
+		return CircuitClosed
+	case now.Before(st.trippedUntil):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// observe 记录一次延迟到 EWMA
+func (st *healthState) observe(d time.Duration) {
+	if st.ewma == 0 {
+		st.ewma = d
+		return
+	}
+	st.ewma = time.Duration(latencyEWMAAlpha*float64(d) + (1-latencyEWMAAlpha)*float64(st.ewma))
+}
+
+// percentiles 计算最近样本的延迟分位数
+func (st *healthState) percentiles(ps ...float64) []time.Duration {
+	n := st.count
+	if n > latencySampleSize {
+		n = latencySampleSize
+	}
+	out := make([]time.Duration, len(ps))
+	if n == 0 {
+		return out
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, st.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, p := range ps {
+		idx := int(p*float64(n)+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		if idx >= n {
+			idx = n - 1
+		}
+		out[i] = sorted[idx]
+	}
+	return out
+}
+
+// healthStateLocked 获取或创建上游健康状态，调用方需持有 healthMu
+func (s *Server) healthStateLocked(key string) *healthState {
+	st := s.upstreamHealth[key]
+	if st == nil {
+		st = &healthState{}
+		s.upstreamHealth[key] = st
+	}
+	return st
+}
+
+// isUpstreamAvailable 熔断器闭合时上游可用；open 与 half-open 期间只接受探测查询
+func (s *Server) isUpstreamAvailable(u upstream) bool {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	st := s.upstreamHealth[u.key()]
+	return st == nil || st.trippedUntil.IsZero()
+}
+
+// recordFailure 记录一次失败，连续失败达到上游组阈值时熔断并安排探测
+func (s *Server) recordFailure(u upstream, target string, err error) {
+	group := upstreamGroupOf(target)
+	now := time.Now()
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	st := s.healthStateLocked(u.key())
+	st.failures++
+	st.totalFailures++
+	st.lastErr = err.Error()
+	st.lastErrAt = now
+	if st.trippedUntil.IsZero() && st.failures >= s.cfg.GetCircuitFailThreshold(group) {
+		s.tripLocked(st, u, group, now)
+		upstreamCircuitOpened.WithLabelValues(target).Inc()
+	}
+}
+
+// tripLocked 打开熔断器，到期后发送探测查询，调用方需持有 healthMu
+func (s *Server) tripLocked(st *healthState, u upstream, group string, now time.Time) {
+	open := s.cfg.GetCircuitOpenDuration(group)
+	st.trippedUntil = now.Add(open)
+	time.AfterFunc(open, func() { s.probeUpstream(u, group) })
+}
+
+// recordSuccess 记录一次成功应答，闭合熔断器
+func (s *Server) recordSuccess(u upstream, rtt time.Duration) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	st := s.healthStateLocked(u.key())
+	st.failures = 0
+	st.trippedUntil = time.Time{}
+	st.successes++
+	st.observe(rtt)
+	st.samples[st.count%latencySampleSize] = rtt
+	st.count++
+}
+
+// observeLatency 将失败按超时计入 EWMA，使 fastest 策略降低其优先级
+func (s *Server) observeLatency(u upstream, d time.Duration) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.healthStateLocked(u.key()).observe(d)
+}
+
+// probeUpstream 半开探测：向熔断到期的上游发送一次合成查询（根区 NS），
+// 成功则闭合熔断器，失败则重新熔断
+func (s *Server) probeUpstream(u upstream, group string) {
+	s.healthMu.Lock()
+	st := s.upstreamHealth[u.key()]
+	if st == nil || st.trippedUntil.IsZero() || st.probing {
+		// 已被成功应答或手动重置闭合
+		s.healthMu.Unlock()
+		return
+	}
+	st.probing = true
+	s.healthMu.Unlock()
+
+	q := new(mdns.Msg)
+	q.SetQuestion(".", mdns.TypeNS)
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.GetUpstreamTimeout(group))
+	defer cancel()
+	start := time.Now()
+	resp, err := s.exchange(ctx, q, u)
+	rtt := time.Since(start)
+
+	s.healthMu.Lock()
+	st.probing = false
+	if st.trippedUntil.IsZero() {
+		s.healthMu.Unlock()
+		return
+	}
+	if err == nil && resp != nil {
+		s.healthMu.Unlock()
+		s.recordSuccess(u, rtt)
+		upstreamCircuitProbes.WithLabelValues(group, "success").Inc()
+		return
+	}
+	defer s.healthMu.Unlock()
+	if err == nil {
+		err = fmt.Errorf("探测无应答")
+	}
+	now := time.Now()
+	st.totalFailures++
+	st.lastErr = err.Error()
+	st.lastErrAt = now
+	s.tripLocked(st, u, group, now)
+	upstreamCircuitProbes.WithLabelValues(group, "failure").Inc()
+}
+
+// UpstreamStatus 上游健康状态快照
+type UpstreamStatus struct {
+	Group         string     `json:"group"`
+	Address       string     `json:"address"`
+	Protocol      string     `json:"protocol"`
+	State         string     `json:"state"` // closed / open / half-open
+	Failures      int        `json:"failures"`
+	TotalFailures int64      `json:"total_failures"`
+	Successes     int64      `json:"successes"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+	OpenUntil     *time.Time `json:"open_until,omitempty"`
+	FailThreshold int        `json:"fail_threshold"`
+	OpenSeconds   int        `json:"open_seconds"`
+	EWMAMs        float64    `json:"latency_ewma_ms"`
+	P50Ms         float64    `json:"latency_p50_ms"`
+	P90Ms         float64    `json:"latency_p90_ms"`
+	P99Ms         float64    `json:"latency_p99_ms"`
+	Samples       int        `json:"samples"`
+}
+
+// upstreamGroups 返回所有已配置的上游组（含条件转发区域与私有反向解析）
+func (s *Server) upstreamGroups() ([]string, map[string][]string) {
+	names := []string{UpstreamGroupChina, UpstreamGroupIntl, UpstreamGroupAdguard}
+	groups := map[string][]string{
+		UpstreamGroupChina:   s.cfg.GetChinaUpstreams(),
+		UpstreamGroupIntl:    s.cfg.GetIntlUpstreams(),
+		UpstreamGroupAdguard: s.cfg.GetAdguardUpstreams(),
+	}
+	if s.zones != nil {
+		var zones []string
+		for name, z := range s.zones.zones {
+			zones = append(zones, name)
+			groups["zone:"+name] = z.Upstreams
+		}
+		sort.Strings(zones)
+		for _, z := range zones {
+			names = append(names, "zone:"+z)
+		}
+	}
+	if s.recursor != nil {
+		names = append(names, RecursiveUpstream)
+		groups[RecursiveUpstream] = []string{RecursiveUpstream}
+	}
+	if ups := s.cfg.PrivatePTR.Upstreams; len(ups) > 0 {
+		names = append(names, "private-ptr")
+		groups["private-ptr"] = ups
+	}
+	return names, groups
+}
+
+// GetUpstreamStatus 返回所有已配置上游的熔断状态、失败计数与延迟分位数
+func (s *Server) GetUpstreamStatus() []UpstreamStatus {
+	names, groups := s.upstreamGroups()
+	now := time.Now()
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	var out []UpstreamStatus
+	for _, group := range names {
+		for _, addr := range groups[group] {
+			u := parseUpstream(addr)
+			status := UpstreamStatus{
+				Group:         group,
+				Address:       addr,
+				Protocol:      u.Proto,
+				State:         CircuitClosed,
+				FailThreshold: s.cfg.GetCircuitFailThreshold(group),
+				OpenSeconds:   int(s.cfg.GetCircuitOpenDuration(group) / time.Second),
+			}
+			if st := s.upstreamHealth[u.key()]; st != nil {
+				status.State = st.state(now)
+				status.Failures = st.failures
+				status.TotalFailures = st.totalFailures
+				status.Successes = st.successes
+				status.LastError = st.lastErr
+				if !st.lastErrAt.IsZero() {
+					t := st.lastErrAt
+					status.LastErrorAt = &t
+				}
+				if !st.trippedUntil.IsZero() {
+					t := st.trippedUntil
+					status.OpenUntil = &t
+				}
+				status.EWMAMs = ms(st.ewma)
+				p := st.percentiles(0.5, 0.9, 0.99)
+				status.P50Ms, status.P90Ms, status.P99Ms = ms(p[0]), ms(p[1]), ms(p[2])
+				status.Samples = st.count
+				if status.Samples > latencySampleSize {
+					status.Samples = latencySampleSize
+				}
+			}
+			out = append(out, status)
+		}
+	}
+	return out
+}
+
+// ResetUpstream 手动闭合熔断器并清除失败计数，address 为空时重置全部上游
+func (s *Server) ResetUpstream(address string) error {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	if address == "" {
+		for _, st := range s.upstreamHealth {
+			st.failures = 0
+			st.trippedUntil = time.Time{}
+		}
+		return nil
+	}
+	st := s.upstreamHealth[parseUpstream(address).key()]
+	if st == nil {
+		return fmt.Errorf("上游不存在或尚未使用: %s", address)
+	}
+	st.failures = 0
+	st.trippedUntil = time.Time{}
+	return nil
+}
@@ -0,0 +1,240 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 未命中规则的域名在 china -> intl 回退中的解析结果
+const (
+	OutcomeChinaOK        = "china_ok"         // china 上游应答被采用
+	OutcomeChinaFailed    = "china_failed"     // china 上游失败或无应答
+	OutcomeChinaPoisoned  = "china_poisoned"   // china 上游应答被污染（次数记录在 gfw_candidates）
+	OutcomeChinaForeignIP = "china_foreign_ip" // china 上游应答 IP 不在中国（常见于 CDN 境外节点），不参与学习
+)
+
+// 学习规则状态
+const (
+	LearnedStatusLearned   = "learned"   // 自动学习，已生效，待审核
+	LearnedStatusConfirmed = "confirmed" // 已确认
+	LearnedStatusRejected  = "rejected"  // 已拒绝，不生效且不再自动学习
+)
+
+// defaultLearningThreshold 自动学习所需的连续一致观测次数
+const defaultLearningThreshold = 5
+
+// DomainOutcome 域名的累计解析结果
+type DomainOutcome struct {
+	Domain        string    `json:"domain"`
+	ChinaOK       int64     `json:"china_ok"`
+	ChinaFailed   int64     `json:"china_failed"`
+	ChinaPoisoned int64     `json:"china_poisoned"` // 取自 GFW 学习候选的命中次数
+	ChinaForeign  int64     `json:"china_foreign_ip"`
+	IntlUsed      int64     `json:"intl_used"`
+	Streak        int       `json:"streak"`          // 连续一致的观测次数
+	StreakVote    string    `json:"streak_category"` // 连续观测指向的分类 china / gfw
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LearnedRule 自动学习的分流规则
+type LearnedRule struct {
+	Domain       string    `json:"domain"`
+	Category     string    `json:"category"` // china / gfw
+	Status       string    `json:"status"`   // learned / confirmed / rejected
+	Observations int       `json:"observations"`
+	Provenance   string    `json:"provenance"` // 学习依据
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// active 规则是否参与分流
+func (r *LearnedRule) active() bool {
+	return r.Status == LearnedStatusLearned || r.Status == LearnedStatusConfirmed
+}
+
+// observation 一次回退解析的观测
+type observation struct {
+	domain  string
+	outcome string
+	intlOK  bool
+}
+
+// RuleLearner 记录未命中规则域名的解析结果，连续一致的观测达到阈值后自动学习为 china / gfw 规则
+type RuleLearner struct {
+	store     *SQLiteManager
+	threshold int
+	onChange  func() // 生效规则变化时调用（重载规则）
+
+	events chan observation
+
+	mu    sync.Mutex
+	rules map[string]*LearnedRule
+}
+
+// NewRuleLearner 创建规则学习器，未启用或未使用 SQLite 存储时返回 nil
+func NewRuleLearner(cfg *Config, storage StorageManager, onChange func()) *RuleLearner {
+	if !cfg.Learning.Enabled {
+		return nil
+	}
+	sm, ok := storage.(*SQLiteManager)
+	if !ok {
+		log.Printf("规则学习需要启用 SQLite 存储，已禁用")
+		return nil
+	}
+	l := &RuleLearner{
+		store:     sm,
+		threshold: cfg.GetLearningThreshold(),
+		onChange:  onChange,
+		events:    make(chan observation, 1024),
+		rules:     make(map[string]*LearnedRule),
+	}
+	rules, err := sm.GetLearnedRules()
+	if err != nil {
+		log.Printf("加载学习规则失败: %v", err)
+	}
+	for i := range rules {
+		l.rules[rules[i].Domain] = &rules[i]
+	}
+	go l.run()
+	return l
+}
+
+// Observe 记录一次回退解析结果，队列已满时丢弃
+func (l *RuleLearner) Observe(domain, outcome string, intlOK bool) {
+	if l == nil {
+		return
+	}
+	select {
+	case l.events <- observation{domain: domain, outcome: outcome, intlOK: intlOK}:
+	default:
+	}
+}
+
+// vote 观测指向的分类：china 成功为 china，china 失败或被污染且 intl 成功为 gfw，其余不计。
+// 应答 IP 不在中国多为 CDN 调度到境外节点，不能说明域名被墙，不计入 gfw
+func (o observation) vote() string {
+	switch {
+	case o.outcome == OutcomeChinaOK:
+		return "china"
+	case o.outcome == OutcomeChinaForeignIP:
+		return ""
+	case o.intlOK:
+		return "gfw"
+	}
+	return ""
+}
+
+// run 串行处理观测
+func (l *RuleLearner) run() {
+	for o := range l.events {
+		if l.handle(o) {
+			l.onChange()
+		}
+	}
+}
+
+// handle 写入一次观测，连续一致的观测达到阈值时学习规则，返回生效规则是否变化
+func (l *RuleLearner) handle(o observation) bool {
+	out, err := l.store.RecordDomainOutcome(o.domain, o.outcome, o.intlOK, o.vote())
+	if err != nil {
+		log.Printf("记录域名解析结果失败: %v", err)
+		return false
+	}
+	if out.StreakVote == "" || out.Streak < l.threshold {
+		return false
+	}
+	return l.promote(out)
+}
+
+// promote 将域名学习为规则，已有规则（包括已拒绝）时不处理
+func (l *RuleLearner) promote(out *DomainOutcome) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, exists := l.rules[out.Domain]; exists {
+		return false
+	}
+
+	reason := "china 上游应答被采用"
+	if out.StreakVote == "gfw" {
+		reason = "china 上游失败或被污染后由 intl 解析"
+	}
+	now := time.Now()
+	rule := &LearnedRule{
+		Domain:       out.Domain,
+		Category:     out.StreakVote,
+		Status:       LearnedStatusLearned,
+		Observations: out.Streak,
+		Provenance: fmt.Sprintf("自动学习：连续 %d 次 %s（累计 china 成功 %d / 失败 %d / 污染 %d / 境外 IP %d，intl %d）",
+			out.Streak, reason, out.ChinaOK, out.ChinaFailed, out.ChinaPoisoned, out.ChinaForeign, out.IntlUsed),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := l.store.SaveLearnedRule(rule); err != nil {
+		log.Printf("保存学习规则失败: %v", err)
+		return false
+	}
+	l.rules[rule.Domain] = rule
+	log.Printf("学习规则 %s -> %s", rule.Domain, rule.Category)
+	return true
+}
+
+// Rules 返回分类下生效的学习规则（精确匹配）
+func (l *RuleLearner) Rules(category string) []string {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []string
+	for _, r := range l.rules {
+		if r.Category == category && r.active() {
+			out = append(out, DomainRule{Type: RuleTypeFull, Value: r.Domain}.String())
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// List 列出学习规则，status 为空时返回全部，按更新时间降序
+func (l *RuleLearner) List(status string) []LearnedRule {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]LearnedRule, 0, len(l.rules))
+	for _, r := range l.rules {
+		if status == "" || r.Status == status {
+			out = append(out, *r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	return out
+}
+
+// SetStatus 确认或拒绝学习规则
+func (l *RuleLearner) SetStatus(domain, status string) (*LearnedRule, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	l.mu.Lock()
+	r, ok := l.rules[domain]
+	if !ok {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("学习规则不存在: %s", domain)
+	}
+	updated := *r
+	updated.Status = status
+	updated.UpdatedAt = time.Now()
+	if err := l.store.SaveLearnedRule(&updated); err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	changed := r.active() != updated.active()
+	l.rules[domain] = &updated
+	l.mu.Unlock()
+
+	if changed {
+		l.onChange()
+	}
+	return &updated, nil
+}
@@ -0,0 +1,187 @@
+package dns
+
+import (
+	"testing"
+)
+
+// newTestLearner 使用临时 SQLite 数据库创建规则学习器，changes 记录生效规则变化的次数
+func newTestLearner(t *testing.T, threshold int) (*RuleLearner, *int) {
+	t.Helper()
+	cfg := &Config{}
+	cfg.Persistence.DataDir = t.TempDir()
+	cfg.Learning.Enabled = true
+	cfg.Learning.Threshold = threshold
+	sm, err := NewSQLiteManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sm.Close() })
+
+	changes := new(int)
+	l := NewRuleLearner(cfg, sm, func() { *changes++ })
+	if l == nil {
+		t.Fatal("learner not created")
+	}
+	return l, changes
+}
+
+// observe 同步处理观测，生效规则变化时与 run 一样调用 onChange
+func (l *RuleLearner) observe(t *testing.T, domain, outcome string, intlOK bool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if l.handle(observation{domain: domain, outcome: outcome, intlOK: intlOK}) {
+			l.onChange()
+		}
+	}
+}
+
+func TestLearnerStreak(t *testing.T) {
+	l, _ := newTestLearner(t, 3)
+
+	l.observe(t, "example.com", OutcomeChinaOK, false, 2)
+	// 不同分类的观测重新开始计数
+	l.observe(t, "example.com", OutcomeChinaFailed, true, 1)
+	out, err := l.store.GetDomainOutcome("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.StreakVote != "gfw" || out.Streak != 1 {
+		t.Errorf("streak = %s x%d, want gfw x1", out.StreakVote, out.Streak)
+	}
+
+	// china 与 intl 都失败时不计票，不打断连续计数
+	l.observe(t, "example.com", OutcomeChinaFailed, false, 1)
+	l.observe(t, "example.com", OutcomeChinaPoisoned, true, 1)
+	out, _ = l.store.GetDomainOutcome("example.com")
+	if out.StreakVote != "gfw" || out.Streak != 2 {
+		t.Errorf("streak = %s x%d, want gfw x2", out.StreakVote, out.Streak)
+	}
+	if out.ChinaOK != 2 || out.ChinaFailed != 2 || out.IntlUsed != 2 {
+		t.Errorf("outcome = %+v", out)
+	}
+	if len(l.List("")) != 0 {
+		t.Errorf("rules learned below threshold: %v", l.List(""))
+	}
+}
+
+func TestLearnerPromotion(t *testing.T) {
+	l, changes := newTestLearner(t, 3)
+
+	l.observe(t, "cn.example", OutcomeChinaOK, false, 2)
+	if got := l.Rules("china"); len(got) != 0 {
+		t.Fatalf("rules = %v before threshold", got)
+	}
+	l.observe(t, "cn.example", OutcomeChinaOK, false, 1)
+	if got := l.Rules("china"); len(got) != 1 || got[0] != "full:cn.example" {
+		t.Fatalf("china rules = %v, want [full:cn.example]", got)
+	}
+	if *changes != 1 {
+		t.Errorf("onChange called %d times, want 1", *changes)
+	}
+
+	// 已学习的域名不重复学习
+	l.observe(t, "cn.example", OutcomeChinaOK, false, 3)
+	if *changes != 1 {
+		t.Errorf("onChange called %d times after relearn, want 1", *changes)
+	}
+
+	// 被污染的次数取自 GFW 学习候选
+	for i := 0; i < 2; i++ {
+		if err := l.store.RecordGFWCandidate("blocked.example", "8.7.198.45"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.observe(t, "blocked.example", OutcomeChinaPoisoned, true, 3)
+	if got := l.Rules("gfw"); len(got) != 1 || got[0] != "full:blocked.example" {
+		t.Fatalf("gfw rules = %v, want [full:blocked.example]", got)
+	}
+	out, err := l.store.GetDomainOutcome("blocked.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ChinaPoisoned != 2 {
+		t.Errorf("china_poisoned = %d, want 2 (gfw_candidates hits)", out.ChinaPoisoned)
+	}
+}
+
+func TestLearnerForeignIPNotGFW(t *testing.T) {
+	l, changes := newTestLearner(t, 3)
+
+	// CDN 境外节点：china 应答 IP 不在中国，intl 成功，不应学习为 gfw
+	l.observe(t, "cdn.example", OutcomeChinaForeignIP, true, 10)
+	if got := l.Rules("gfw"); len(got) != 0 {
+		t.Fatalf("gfw rules = %v, want none", got)
+	}
+	if *changes != 0 {
+		t.Errorf("onChange called %d times, want 0", *changes)
+	}
+	out, err := l.store.GetDomainOutcome("cdn.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.ChinaForeign != 10 || out.IntlUsed != 10 || out.Streak != 0 {
+		t.Errorf("outcome = %+v, want 10 foreign-ip observations without streak", out)
+	}
+
+	// 境外 IP 不打断 china 的连续计数
+	l.observe(t, "cdn.example", OutcomeChinaOK, false, 2)
+	l.observe(t, "cdn.example", OutcomeChinaForeignIP, true, 1)
+	l.observe(t, "cdn.example", OutcomeChinaOK, false, 1)
+	if got := l.Rules("china"); len(got) != 1 {
+		t.Errorf("china rules = %v, want [full:cdn.example]", got)
+	}
+}
+
+func TestLearnerRejectNoRelearn(t *testing.T) {
+	l, changes := newTestLearner(t, 2)
+
+	l.observe(t, "example.org", OutcomeChinaFailed, true, 2)
+	if got := l.Rules("gfw"); len(got) != 1 {
+		t.Fatalf("gfw rules = %v, want [full:example.org]", got)
+	}
+
+	r, err := l.SetStatus("Example.ORG.", LearnedStatusRejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != LearnedStatusRejected {
+		t.Errorf("status = %s, want rejected", r.Status)
+	}
+	if got := l.Rules("gfw"); len(got) != 0 {
+		t.Errorf("gfw rules = %v after reject, want none", got)
+	}
+	if *changes != 2 {
+		t.Errorf("onChange called %d times, want 2", *changes)
+	}
+
+	// 已拒绝的域名不再自动学习，无论之后的观测指向哪个分类
+	l.observe(t, "example.org", OutcomeChinaFailed, true, 5)
+	l.observe(t, "example.org", OutcomeChinaOK, false, 5)
+	if got := l.Rules("gfw"); len(got) != 0 {
+		t.Errorf("gfw rules = %v, want none", got)
+	}
+	if got := l.Rules("china"); len(got) != 0 {
+		t.Errorf("china rules = %v, want none", got)
+	}
+	if got := l.List(LearnedStatusRejected); len(got) != 1 || got[0].Category != "gfw" {
+		t.Errorf("rejected = %v, want example.org -> gfw", got)
+	}
+	if *changes != 2 {
+		t.Errorf("onChange called %d times, want 2", *changes)
+	}
+
+	// 拒绝在重启后保持
+	cfg := &Config{}
+	cfg.Learning.Enabled = true
+	cfg.Learning.Threshold = 2
+	restarted := NewRuleLearner(cfg, l.store, func() {})
+	restarted.observe(t, "example.org", OutcomeChinaOK, false, 5)
+	if got := restarted.Rules("china"); len(got) != 0 {
+		t.Errorf("china rules after restart = %v, want none", got)
+	}
+
+	// 拒绝不存在的规则返回错误
+	if _, err := l.SetStatus("missing.example", LearnedStatusRejected); err == nil {
+		t.Error("SetStatus on unknown domain succeeded")
+	}
+}
@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// DoQ 协议错误码 (RFC 9250 4.3)
+const doqProtocolError quic.ApplicationErrorCode = 0x2
+
+// LoadServerTLSConfig 加载加密监听使用的证书，nextProtos 为协商的 ALPN
+func LoadServerTLSConfig(cfg *Config, nextProtos ...string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("加载 TLS 证书失败: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   nextProtos,
+	}, nil
+}
+
+// ServeDoT 在 TLS 监听上提供 DNS over TLS (RFC 7858)
+func (s *Server) ServeDoT(ln net.Listener) {
+	srv := &mdns.Server{Handler: mdns.HandlerFunc(s.handle), Listener: ln, Net: "tcp-tls"}
+	if err := srv.ActivateAndServe(); err != nil {
+		log.Printf("dot serve err: %v", err)
+	}
+}
+
+// ServeDoH 在 TLS 监听上提供 DNS over HTTPS (RFC 8484)
+func (s *Server) ServeDoH(ln net.Listener, path string) {
+	mux := http.NewServeMux()
+	mux.Handle(path, s.DoHHandler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("doh serve err: %v", err)
+	}
+}
+
+// DoHHandler 返回 DoH 请求处理器，可挂载到任意 HTTP 路由上
+func (s *Server) DoHHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wire []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			wire, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohMediaType {
+				http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			wire, err = io.ReadAll(io.LimitReader(r.Body, mdns.MaxMsgSize))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil || len(wire) == 0 {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+
+		req := new(mdns.Msg)
+		if err := req.Unpack(wire); err != nil {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+
+		mw := newMsgWriter(r.Context().Value(http.LocalAddrContextKey), r.RemoteAddr, "tcp")
+		s.handle(mw, req)
+		if mw.msg == nil {
+			http.Error(w, "no response", http.StatusInternalServerError)
+			return
+		}
+
+		out, err := mw.msg.Pack()
+		if err != nil {
+			http.Error(w, "pack response failed", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(minAnswerTTL(mw.msg))))
+		_, _ = w.Write(out)
+	})
+}
+
+// ServeDoQ 在 QUIC 监听上提供 DNS over QUIC (RFC 9250)
+func (s *Server) ServeDoQ(ln *quic.Listener) {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if err != nil {
+			if !errors.Is(err, quic.ErrServerClosed) {
+				log.Printf("doq serve err: %v", err)
+			}
+			return
+		}
+		go s.serveDoQConn(conn)
+	}
+}
+
+// serveDoQConn 处理单个 QUIC 连接，每个双向流承载一个查询
+func (s *Server) serveDoQConn(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			_ = stream.SetDeadline(time.Now().Add(10 * time.Second))
+
+			req, err := readDoQMsg(stream)
+			if err != nil {
+				stream.CancelRead(quic.StreamErrorCode(doqProtocolError))
+				return
+			}
+			// RFC 9250 4.2.1: 报文 ID 非 0 视为协议错误
+			if req.Id != 0 {
+				_ = conn.CloseWithError(doqProtocolError, "message id must be 0")
+				return
+			}
+
+			mw := newMsgWriter(conn.LocalAddr(), conn.RemoteAddr().String(), "udp")
+			s.handle(mw, req)
+			if mw.msg == nil {
+				return
+			}
+			mw.msg.Id = 0
+			_ = writeDoQMsg(stream, mw.msg)
+		}()
+	}
+}
+
+// minAnswerTTL 返回响应中记录的最小 TTL，用于 HTTP 缓存头
+func minAnswerTTL(m *mdns.Msg) uint32 {
+	var ttl uint32
+	first := true
+	for _, rrs := range [][]mdns.RR{m.Answer, m.Ns} {
+		for _, rr := range rrs {
+			if first || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				first = false
+			}
+		}
+	}
+	return ttl
+}
+
+// msgWriter 将 Server.handle 的输出收集为报文，供 DoH / DoQ 复用同一处理流程
+type msgWriter struct {
+	local  net.Addr
+	remote net.Addr
+	msg    *mdns.Msg
+}
+
+// newMsgWriter 创建报文收集器，remote 为客户端 host:port
+func newMsgWriter(local interface{}, remote, network string) *msgWriter {
+	mw := &msgWriter{}
+	if addr, ok := local.(net.Addr); ok {
+		mw.local = addr
+	}
+	host, port, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	p, _ := strconv.Atoi(port)
+	if network == "udp" {
+		mw.remote = &net.UDPAddr{IP: net.ParseIP(host), Port: p}
+	} else {
+		mw.remote = &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+	}
+	return mw
+}
+
+func (mw *msgWriter) LocalAddr() net.Addr  { return mw.local }
+func (mw *msgWriter) RemoteAddr() net.Addr { return mw.remote }
+
+func (mw *msgWriter) WriteMsg(m *mdns.Msg) error {
+	mw.msg = m
+	return nil
+}
+
+func (mw *msgWriter) Write(b []byte) (int, error) {
+	m := new(mdns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	mw.msg = m
+	return len(b), nil
+}
+
+func (mw *msgWriter) Close() error        { return nil }
+func (mw *msgWriter) TsigStatus() error   { return nil }
+func (mw *msgWriter) TsigTimersOnly(bool) {}
+func (mw *msgWriter) Hijack()             {}
@@ -0,0 +1,468 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// defaultLocalTTL 本地记录未指定 TTL 时使用的默认值
+const defaultLocalTTL = 300
+
+// hostsCheckInterval hosts 文件变更检查间隔
+const hostsCheckInterval = 10 * time.Second
+
+// 本地记录来源
+const (
+	LocalSourceConfig = "config" // 配置文件 local_records
+	LocalSourceHosts  = "hosts"  // hosts_file
+	LocalSourceAPI    = "api"    // 管理 API 添加，持久化在 SQLite
+)
+
+// LocalRecord 本地权威记录
+type LocalRecord struct {
+	ID        int    `json:"id" yaml:"-"`
+	Name      string `json:"name" yaml:"name"`
+	Type      string `json:"type" yaml:"type"` // A, AAAA, CNAME, TXT, PTR
+	Value     string `json:"value" yaml:"value"`
+	TTL       uint32 `json:"ttl" yaml:"ttl"`
+	Source    string `json:"source" yaml:"-"`
+	CreatedAt int64  `json:"created_at" yaml:"-"`
+	UpdatedAt int64  `json:"updated_at" yaml:"-"`
+}
+
+// Normalize 标准化并校验记录
+func (r *LocalRecord) Normalize() error {
+	r.Name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(r.Name)), ".")
+	r.Type = strings.ToUpper(strings.TrimSpace(r.Type))
+	r.Value = strings.TrimSpace(r.Value)
+	if r.Name == "" {
+		return fmt.Errorf("记录名称不能为空")
+	}
+	if _, ok := mdns.IsDomainName(r.Name); !ok {
+		return fmt.Errorf("无效的记录名称: %s", r.Name)
+	}
+	if r.TTL == 0 {
+		r.TTL = defaultLocalTTL
+	}
+	_, err := r.toRR()
+	return err
+}
+
+// toRR 将记录转换为 DNS 资源记录
+func (r *LocalRecord) toRR() (mdns.RR, error) {
+	hdr := mdns.RR_Header{Name: mdns.Fqdn(r.Name), Class: mdns.ClassINET, Ttl: r.TTL}
+	switch r.Type {
+	case "A":
+		ip, err := netip.ParseAddr(r.Value)
+		if err != nil || !ip.Is4() {
+			return nil, fmt.Errorf("无效的 IPv4 地址: %s", r.Value)
+		}
+		hdr.Rrtype = mdns.TypeA
+		return &mdns.A{Hdr: hdr, A: ip.AsSlice()}, nil
+	case "AAAA":
+		ip, err := netip.ParseAddr(r.Value)
+		if err != nil || !ip.Is6() || ip.Is4In6() {
+			return nil, fmt.Errorf("无效的 IPv6 地址: %s", r.Value)
+		}
+		hdr.Rrtype = mdns.TypeAAAA
+		return &mdns.AAAA{Hdr: hdr, AAAA: ip.AsSlice()}, nil
+	case "CNAME", "PTR":
+		target := strings.ToLower(r.Value)
+		if _, ok := mdns.IsDomainName(target); !ok || target == "" {
+			return nil, fmt.Errorf("无效的目标域名: %s", r.Value)
+		}
+		if r.Type == "CNAME" {
+			hdr.Rrtype = mdns.TypeCNAME
+			return &mdns.CNAME{Hdr: hdr, Target: mdns.Fqdn(target)}, nil
+		}
+		hdr.Rrtype = mdns.TypePTR
+		return &mdns.PTR{Hdr: hdr, Ptr: mdns.Fqdn(target)}, nil
+	case "TXT":
+		if r.Value == "" {
+			return nil, fmt.Errorf("TXT 记录内容不能为空")
+		}
+		hdr.Rrtype = mdns.TypeTXT
+		return &mdns.TXT{Hdr: hdr, Txt: splitTXT(r.Value)}, nil
+	}
+	return nil, fmt.Errorf("不支持的记录类型: %s", r.Type)
+}
+
+// splitTXT 按 255 字节拆分 TXT 字符串
+func splitTXT(s string) []string {
+	var out []string
+	for len(s) > 255 {
+		out = append(out, s[:255])
+		s = s[255:]
+	}
+	return append(out, s)
+}
+
+// localZone 本地记录索引，按小写且不带末尾点的名称组织
+type localZone struct {
+	records map[string][]mdns.RR
+}
+
+// localMaxCNAMEHops 本地 CNAME 链最多跟随的跳数
+const localMaxCNAMEHops = 8
+
+// lookup 查询本地记录，名称存在时 found 为 true（即使没有对应类型的记录，也应返回 NODATA）。
+// 本地记录均为 IN 类，其他类的查询不由本地应答
+func (z *localZone) lookup(name string, qtype, qclass uint16) (answers []mdns.RR, found bool) {
+	if z == nil || (qclass != mdns.ClassINET && qclass != mdns.ClassANY) {
+		return nil, false
+	}
+	rrs, ok := z.records[name]
+	if !ok {
+		return nil, false
+	}
+
+	// 跟随本地 CNAME 链直到得到目标类型的记录、链尾不在本地或达到跳数上限，检测环
+	visited := map[string]bool{name: true}
+	for hop := 0; hop <= localMaxCNAMEHops; hop++ {
+		var cname *mdns.CNAME
+		matched := false
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == qtype || qtype == mdns.TypeANY {
+				answers = append(answers, mdns.Copy(rr))
+				matched = true
+			} else if c, ok := rr.(*mdns.CNAME); ok {
+				cname = c
+			}
+		}
+		if matched || cname == nil || qtype == mdns.TypeCNAME {
+			break
+		}
+		answers = append(answers, mdns.Copy(cname))
+		target := strings.TrimSuffix(cname.Target, ".")
+		if visited[target] {
+			break
+		}
+		visited[target] = true
+		rrs = z.records[target]
+	}
+	return answers, true
+}
+
+// LocalRecordManager 本地权威记录管理器：合并配置、hosts 文件与 API 记录，变更实时生效
+type LocalRecordManager struct {
+	cfg   *Config
+	store *SQLiteManager // 为 nil 时 API 记录仅保存在内存
+
+	mu        sync.Mutex
+	apiRecs   []*LocalRecord
+	nextID    int
+	hostsRecs []*LocalRecord
+	hostsMod  time.Time
+
+	zone      atomic.Pointer[localZone]
+	rebuildMu sync.Mutex // 串行化复制、编译与发布，并发修改时最后发布的总是最新的记录
+}
+
+// NewLocalRecordManager 创建本地记录管理器并加载所有来源的记录
+func NewLocalRecordManager(cfg *Config, storage StorageManager) *LocalRecordManager {
+	m := &LocalRecordManager{cfg: cfg, nextID: 1}
+	if sm, ok := storage.(*SQLiteManager); ok {
+		m.store = sm
+		recs, err := sm.GetLocalRecords()
+		if err != nil {
+			log.Printf("加载本地记录失败: %v", err)
+		}
+		for _, r := range recs {
+			r.Source = LocalSourceAPI
+			m.apiRecs = append(m.apiRecs, r)
+			if r.ID >= m.nextID {
+				m.nextID = r.ID + 1
+			}
+		}
+	}
+	m.loadHosts()
+	m.rebuild()
+	return m
+}
+
+// Start 定期检查 hosts 文件变更
+func (m *LocalRecordManager) Start() {
+	if m.cfg.HostsFile == "" {
+		return
+	}
+	ticker := time.NewTicker(hostsCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(m.cfg.HostsFile)
+		if err != nil {
+			continue
+		}
+		m.mu.Lock()
+		changed := !info.ModTime().Equal(m.hostsMod)
+		m.mu.Unlock()
+		if changed {
+			m.Reload()
+		}
+	}
+}
+
+// Reload 重新读取 hosts 文件与配置记录
+func (m *LocalRecordManager) Reload() {
+	m.loadHosts()
+	m.rebuild()
+}
+
+// Lookup 查询本地记录
+func (m *LocalRecordManager) Lookup(name string, qtype, qclass uint16) ([]mdns.RR, bool) {
+	return m.zone.Load().lookup(name, qtype, qclass)
+}
+
+// List 列出所有本地记录（包含配置与 hosts 来源，仅 API 来源可修改）
+func (m *LocalRecordManager) List() []LocalRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []LocalRecord
+	for _, r := range m.configRecords() {
+		out = append(out, *r)
+	}
+	for _, r := range m.hostsRecs {
+		out = append(out, *r)
+	}
+	for _, r := range m.apiRecs {
+		out = append(out, *r)
+	}
+	return out
+}
+
+// Add 添加一条 API 记录
+func (m *LocalRecordManager) Add(rec LocalRecord) (*LocalRecord, error) {
+	if err := rec.Normalize(); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	rec.ID = 0
+	rec.Source = LocalSourceAPI
+	rec.CreatedAt = now
+	rec.UpdatedAt = now
+
+	m.mu.Lock()
+	if m.store != nil {
+		if err := m.store.SaveLocalRecord(&rec); err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+	} else {
+		rec.ID = m.nextID
+	}
+	if rec.ID >= m.nextID {
+		m.nextID = rec.ID + 1
+	}
+	m.apiRecs = append(m.apiRecs, &rec)
+	m.mu.Unlock()
+
+	m.rebuild()
+	return &rec, nil
+}
+
+// Update 更新一条 API 记录
+func (m *LocalRecordManager) Update(id int, rec LocalRecord) (*LocalRecord, error) {
+	if err := rec.Normalize(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	var existing *LocalRecord
+	for _, r := range m.apiRecs {
+		if r.ID == id {
+			existing = r
+			break
+		}
+	}
+	if existing == nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("本地记录不存在: %d", id)
+	}
+	rec.ID = id
+	rec.Source = LocalSourceAPI
+	rec.CreatedAt = existing.CreatedAt
+	rec.UpdatedAt = time.Now().Unix()
+	if m.store != nil {
+		if err := m.store.SaveLocalRecord(&rec); err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+	}
+	*existing = rec
+	m.mu.Unlock()
+
+	m.rebuild()
+	return &rec, nil
+}
+
+// Delete 删除一条 API 记录
+func (m *LocalRecordManager) Delete(id int) error {
+	m.mu.Lock()
+	idx := -1
+	for i, r := range m.apiRecs {
+		if r.ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("本地记录不存在: %d", id)
+	}
+	if m.store != nil {
+		if err := m.store.DeleteLocalRecord(id); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	m.apiRecs = append(m.apiRecs[:idx], m.apiRecs[idx+1:]...)
+	m.mu.Unlock()
+
+	m.rebuild()
+	return nil
+}
+
+// configRecords 返回配置文件中的有效记录，调用方需持有 m.mu
+func (m *LocalRecordManager) configRecords() []*LocalRecord {
+	out := make([]*LocalRecord, 0, len(m.cfg.LocalRecords))
+	for _, r := range m.cfg.LocalRecords {
+		rec := r
+		if err := rec.Normalize(); err != nil {
+			continue
+		}
+		rec.Source = LocalSourceConfig
+		out = append(out, &rec)
+	}
+	return out
+}
+
+// loadHosts 读取 hosts 文件
+func (m *LocalRecordManager) loadHosts() {
+	path := m.cfg.HostsFile
+	if path == "" {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("读取 hosts 文件失败: %v", err)
+		return
+	}
+	defer f.Close()
+
+	var mod time.Time
+	if info, err := f.Stat(); err == nil {
+		mod = info.ModTime()
+	}
+
+	var recs []*LocalRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		ip = ip.Unmap()
+		typ := "A"
+		if ip.Is6() {
+			typ = "AAAA"
+		}
+		for _, host := range fields[1:] {
+			rec := &LocalRecord{Name: host, Type: typ, Value: ip.String(), Source: LocalSourceHosts}
+			if err := rec.Normalize(); err != nil {
+				continue
+			}
+			recs = append(recs, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("读取 hosts 文件失败: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	m.hostsRecs = recs
+	m.hostsMod = mod
+	m.mu.Unlock()
+	log.Printf("hosts 文件已加载: %d 条记录", len(recs))
+}
+
+// rebuild 由全部来源重建索引；地址记录自动生成对应的 PTR（已有显式 PTR 时不覆盖）
+func (m *LocalRecordManager) rebuild() {
+	m.rebuildMu.Lock()
+	defer m.rebuildMu.Unlock()
+
+	m.mu.Lock()
+	all := m.configRecords()
+	all = append(all, m.hostsRecs...)
+	for _, r := range m.apiRecs {
+		rec := *r // API 记录可能被 Update 原地修改，复制后再使用
+		all = append(all, &rec)
+	}
+	m.mu.Unlock()
+
+	zone := &localZone{records: make(map[string][]mdns.RR)}
+	explicitPTR := make(map[string]bool)
+	for _, r := range all {
+		rr, err := r.toRR()
+		if err != nil {
+			continue
+		}
+		zone.records[r.Name] = append(zone.records[r.Name], rr)
+		if r.Type == "PTR" {
+			explicitPTR[r.Name] = true
+		}
+	}
+	for _, r := range all {
+		if r.Type != "A" && r.Type != "AAAA" {
+			continue
+		}
+		arpa, err := mdns.ReverseAddr(r.Value)
+		if err != nil {
+			continue
+		}
+		arpa = strings.TrimSuffix(arpa, ".")
+		if explicitPTR[arpa] {
+			continue
+		}
+		zone.records[arpa] = append(zone.records[arpa], &mdns.PTR{
+			Hdr: mdns.RR_Header{Name: mdns.Fqdn(arpa), Rrtype: mdns.TypePTR, Class: mdns.ClassINET, Ttl: r.TTL},
+			Ptr: mdns.Fqdn(r.Name),
+		})
+	}
+	for name, rrs := range zone.records {
+		zone.records[name] = dedupRRs(rrs)
+	}
+	m.zone.Store(zone)
+}
+
+// dedupRRs 去除重复的资源记录，保持原有顺序
+func dedupRRs(rrs []mdns.RR) []mdns.RR {
+	seen := make(map[string]bool, len(rrs))
+	out := rrs[:0]
+	for _, rr := range rrs {
+		key := rr.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, rr)
+	}
+	return out
+}
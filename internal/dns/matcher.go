@@ -0,0 +1,329 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 域名规则类型，写法兼容 mihomo 的 full: / domain: / keyword: / regexp: 前缀
+const (
+	RuleTypeDomain   = "domain"   // 后缀匹配（默认），example.com / .example.com / domain:example.com / +.example.com
+	RuleTypeFull     = "full"     // 精确匹配，full:example.com
+	RuleTypeKeyword  = "keyword"  // 关键字匹配，keyword:google
+	RuleTypeRegexp   = "regexp"   // 正则匹配，regexp:^ad[0-9]+\.example\.com$
+	RuleTypeWildcard = "wildcard" // 通配匹配，与 mihomo 一致 * 只匹配单个标签：*.example.com 匹配 a.example.com，不匹配 a.b.example.com
+)
+
+// DomainRule 解析后的域名规则
+type DomainRule struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+
+	re *regexp.Regexp
+}
+
+// regexpCache 编译后的正则缓存，避免代理规则等逐次匹配时重复编译
+var regexpCache sync.Map
+
+func compileRuleRegexp(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.Store(expr, re)
+	return re, nil
+}
+
+// ParseDomainRule 解析一条域名规则
+func ParseDomainRule(raw string) (DomainRule, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return DomainRule{}, fmt.Errorf("空规则")
+	}
+
+	if prefix, value, ok := strings.Cut(raw, ":"); ok {
+		switch strings.ToLower(prefix) {
+		case RuleTypeRegexp:
+			re, err := compileRuleRegexp(value)
+			if err != nil {
+				return DomainRule{}, fmt.Errorf("无效的正则规则 %q: %v", value, err)
+			}
+			return DomainRule{Type: RuleTypeRegexp, Value: value, re: re}, nil
+		case RuleTypeFull:
+			value = strings.Trim(strings.ToLower(strings.TrimSpace(value)), ".")
+			if value == "" {
+				return DomainRule{}, fmt.Errorf("无效的精确规则 %q", raw)
+			}
+			return DomainRule{Type: RuleTypeFull, Value: value}, nil
+		case RuleTypeKeyword:
+			value = strings.ToLower(strings.TrimSpace(value))
+			if value == "" {
+				return DomainRule{}, fmt.Errorf("无效的关键字规则 %q", raw)
+			}
+			return DomainRule{Type: RuleTypeKeyword, Value: value}, nil
+		case RuleTypeDomain:
+			raw = value
+		}
+	}
+
+	raw = strings.ToLower(raw)
+	raw = strings.TrimPrefix(raw, "+.")
+
+	if strings.Contains(raw, "*") {
+		value := strings.Trim(raw, ".")
+		rest := strings.TrimPrefix(value, "*.")
+		if !strings.Contains(rest, "*") && rest != "" {
+			return DomainRule{Type: RuleTypeWildcard, Value: rest}, nil
+		}
+		// 非前导位置或多个通配符：编译为正则，* 匹配单个标签
+		expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(value), `\*`, `[^.]+`) + "$"
+		re, err := compileRuleRegexp(expr)
+		if err != nil {
+			return DomainRule{}, fmt.Errorf("无效的通配规则 %q: %v", raw, err)
+		}
+		return DomainRule{Type: RuleTypeWildcard, Value: value, re: re}, nil
+	}
+
+	d, ok := normalizeDomain(raw)
+	if !ok {
+		return DomainRule{}, fmt.Errorf("无效的域名规则 %q", raw)
+	}
+	return DomainRule{Type: RuleTypeDomain, Value: strings.TrimPrefix(d, ".")}, nil
+}
+
+// String 返回规则的标准写法（后缀规则保持带前导点的历史格式）
+func (r DomainRule) String() string {
+	switch r.Type {
+	case RuleTypeDomain:
+		return "." + r.Value
+	case RuleTypeWildcard:
+		if strings.Contains(r.Value, "*") {
+			return r.Value
+		}
+		return "*." + r.Value
+	default:
+		return r.Type + ":" + r.Value
+	}
+}
+
+// Match 判断单个域名是否命中该规则，name 会被标准化为小写并去除末尾的点
+func (r DomainRule) Match(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	switch r.Type {
+	case RuleTypeDomain:
+		return name == r.Value || strings.HasSuffix(name, "."+r.Value)
+	case RuleTypeFull:
+		return name == r.Value
+	case RuleTypeKeyword:
+		return strings.Contains(name, r.Value)
+	case RuleTypeRegexp:
+		return r.re != nil && r.re.MatchString(name)
+	case RuleTypeWildcard:
+		if r.re != nil {
+			return r.re.MatchString(name)
+		}
+		label, ok := strings.CutSuffix(name, "."+r.Value)
+		return ok && label != "" && !strings.Contains(label, ".")
+	}
+	return false
+}
+
+// domainMatcher 一组规则的组合匹配器：后缀与通配走后缀树，精确走哈希表，关键字与正则顺序匹配
+type domainMatcher struct {
+	suffix   *domainTrie
+	full     map[string]struct{}
+	keywords []string
+	regexps  []*regexp.Regexp
+}
+
+// newDomainMatcher 由已解析的规则构建匹配器
+func newDomainMatcher(rules []DomainRule) *domainMatcher {
+	m := &domainMatcher{
+		suffix: &domainTrie{root: &trieNode{}},
+		full:   make(map[string]struct{}),
+	}
+	for _, r := range rules {
+		switch r.Type {
+		case RuleTypeDomain:
+			m.suffix.insert(r.Value, false)
+		case RuleTypeWildcard:
+			if r.re != nil {
+				m.regexps = append(m.regexps, r.re)
+			} else {
+				m.suffix.insert(r.Value, true)
+			}
+		case RuleTypeFull:
+			m.full[r.Value] = struct{}{}
+		case RuleTypeKeyword:
+			m.keywords = append(m.keywords, r.Value)
+		case RuleTypeRegexp:
+			m.regexps = append(m.regexps, r.re)
+		}
+	}
+	return m
+}
+
+// Match 判断域名是否命中任一规则，name 需为小写且不带末尾的点
+func (m *domainMatcher) Match(name string) bool {
+	if m == nil {
+		return false
+	}
+	if _, ok := m.full[name]; ok {
+		return true
+	}
+	if m.suffix.Match(name) {
+		return true
+	}
+	for _, kw := range m.keywords {
+		if strings.Contains(name, kw) {
+			return true
+		}
+	}
+	for _, re := range m.regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileRules 解析规则列表，返回标准写法列表及匹配器，无效规则会被跳过
+func compileRules(in []string) ([]string, *domainMatcher) {
+	out := make([]string, 0, len(in))
+	rules := make([]DomainRule, 0, len(in))
+	for _, raw := range in {
+		r, err := ParseDomainRule(raw)
+		if err != nil {
+			continue
+		}
+		out = append(out, r.String())
+		rules = append(rules, r)
+	}
+	return out, newDomainMatcher(rules)
+}
+
+// domainTrie 按标签倒序组织的域名后缀树
+//
+// 规则 baidu.com 存储为 com -> baidu，查询 www.baidu.com 时从 com 开始逐个标签向下匹配，
+// 走到任一规则终点即命中，因此 notbaidu.com 不会误命中 baidu.com，查询复杂度为 O(标签数)。
+type domainTrie struct {
+	root *trieNode
+	size int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	end      bool // 该节点是否为一条规则的终点（匹配自身及子域名）
+	sub      bool // 该节点是否为通配规则的终点（仅匹配下一级子域名）
+}
+
+// newDomainTrie 由规则列表构建后缀树
+func newDomainTrie(domains []string) *domainTrie {
+	t := &domainTrie{root: &trieNode{}}
+	for _, d := range domains {
+		t.Insert(d)
+	}
+	return t
+}
+
+// Insert 插入一条后缀规则，规则会被标准化为小写并去除首尾的点
+func (t *domainTrie) Insert(domain string) {
+	t.insert(domain, false)
+}
+
+// insert 插入规则，subOnly 为 true 时仅匹配下一级子域名（*.example.com）
+func (t *domainTrie) insert(domain string, subOnly bool) {
+	domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" {
+		return
+	}
+
+	node := t.root
+	for rest := domain; rest != ""; {
+		var label string
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[i+1:], rest[:i]
+		} else {
+			label, rest = rest, ""
+		}
+		// 已存在更短的后缀规则，更长的规则是冗余的
+		if node.end {
+			return
+		}
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child := node.children[label]
+		if child == nil {
+			child = &trieNode{}
+			node.children[label] = child
+		}
+		node = child
+	}
+	if node.end {
+		return
+	}
+	if subOnly {
+		// 通配只覆盖下一级，更深的子规则仍然有效
+		if !node.sub {
+			node.sub = true
+			t.size++
+		}
+		return
+	}
+	if !node.sub {
+		t.size++
+	}
+	t.size -= node.descendants()
+	node.end = true
+	node.children = nil // 子规则已被该后缀覆盖
+}
+
+// descendants 统计节点之下（不含自身）的规则数量
+func (n *trieNode) descendants() int {
+	count := 0
+	for _, child := range n.children {
+		if child.end || child.sub {
+			count++
+		}
+		count += child.descendants()
+	}
+	return count
+}
+
+// Match 判断域名是否命中任一后缀规则，name 需为小写且不带末尾的点
+func (t *domainTrie) Match(name string) bool {
+	if t == nil {
+		return false
+	}
+	node := t.root
+	for rest := name; rest != ""; {
+		var label string
+		if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+			label, rest = rest[i+1:], rest[:i]
+		} else {
+			label, rest = rest, ""
+		}
+		node = node.children[label]
+		if node == nil {
+			return false
+		}
+		if node.end || (node.sub && rest != "" && !strings.Contains(rest, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len 返回有效规则数量（被更短后缀覆盖的规则不计入）
+func (t *domainTrie) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
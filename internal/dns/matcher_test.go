@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDomainMatcher(t *testing.T) {
+	_, m := compileRules([]string{
+		"baidu.com",
+		".qq.com",
+		"domain:taobao.com",
+		"+.jd.com",
+		"full:exact.example.org",
+		"keyword:doubleclick",
+		`regexp:^ad[0-9]+\.example\.net$`,
+	})
+
+	cases := []struct {
+		name string
+		want bool
+	}{
+		// 后缀
+		{"baidu.com", true},
+		{"www.baidu.com", true},
+		{"a.b.baidu.com", true},
+		{"notbaidu.com", false},
+		{"baidu.com.cn", false},
+		{"im.qq.com", true},
+		{"qq.com", true},
+		{"item.taobao.com", true},
+		{"jd.com", true},
+		{"m.jd.com", true},
+		// 精确
+		{"exact.example.org", true},
+		{"www.exact.example.org", false},
+		{"example.org", false},
+		// 关键字
+		{"stats.doubleclick.net", true},
+		{"doubleclick.com", true},
+		{"double.click.com", false},
+		// 正则
+		{"ad1.example.net", true},
+		{"ad42.example.net", true},
+		{"ad.example.net", false},
+		{"x.ad1.example.net", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := m.Match(c.name); got != c.want {
+			t.Errorf("Match(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestWildcardSingleLabel(t *testing.T) {
+	rules := []string{"*.example.com", "a.*.example.org"}
+	_, m := compileRules(rules)
+
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"a.example.com", true},
+		{"a.b.example.com", false},
+		{"example.com", false},
+		{"a.b.example.org", true},
+		{"a.b.c.example.org", false},
+		{"a.example.org", false},
+	}
+	for _, c := range cases {
+		if got := m.Match(c.name); got != c.want {
+			t.Errorf("matcher.Match(%q) = %v, want %v", c.name, got, c.want)
+		}
+		// 单条规则匹配（代理规则使用）需与匹配器一致
+		single := false
+		for _, raw := range rules {
+			r, err := ParseDomainRule(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			single = single || r.Match(c.name)
+		}
+		if single != c.want {
+			t.Errorf("DomainRule.Match(%q) = %v, want %v", c.name, single, c.want)
+		}
+	}
+}
+
+func TestDomainTrieRedundantRules(t *testing.T) {
+	tr := newDomainTrie([]string{"www.example.com", "example.com", "a.b.example.com", "example.net"})
+	if got := tr.Len(); got != 2 {
+		t.Fatalf("Len() = %d, want 2", got)
+	}
+	for _, name := range []string{"example.com", "x.www.example.com", "example.net"} {
+		if !tr.Match(name) {
+			t.Errorf("Match(%q) = false, want true", name)
+		}
+	}
+	if tr.Match("com") {
+		t.Error("Match(\"com\") = true, want false")
+	}
+}
+
+// benchRules 生成接近真实列表的后缀规则：二级域名与少量三级域名，常见顶级域名混合
+func benchRules(n int) []string {
+	tlds := []string{"com", "cn", "net", "org", "com.cn", "io"}
+	rules := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		d := fmt.Sprintf("site%d.%s", i, tlds[i%len(tlds)])
+		if i%10 == 0 {
+			d = "cdn." + d
+		}
+		rules = append(rules, d)
+	}
+	return rules
+}
+
+// benchQueries 生成命中与未命中各半的查询名称
+func benchQueries(n int) []string {
+	tlds := []string{"com", "cn", "net", "org", "com.cn", "io"}
+	queries := make([]string, 0, 1024)
+	for i := 0; i < 1024; i++ {
+		j := (i * 7919) % n
+		if i%2 == 0 {
+			queries = append(queries, fmt.Sprintf("www.site%d.%s", j, tlds[j%len(tlds)]))
+		} else {
+			queries = append(queries, fmt.Sprintf("www.miss%d.example", j))
+		}
+	}
+	return queries
+}
+
+// linearMatch 改用后缀树之前的逐条后缀比较
+func linearMatch(name string, suffixes []string) bool {
+	for _, sfx := range suffixes {
+		sfx = strings.ToLower(strings.TrimSpace(sfx))
+		if sfx == "" {
+			continue
+		}
+		if strings.HasSuffix(name, strings.TrimPrefix(sfx, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+func benchmarkMatcher(b *testing.B, n int) {
+	_, m := compileRules(benchRules(n))
+	queries := benchQueries(n)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(queries[i%len(queries)])
+	}
+}
+
+func benchmarkLinear(b *testing.B, n int) {
+	suffixes := benchRules(n)
+	queries := benchQueries(n)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearMatch(queries[i%len(queries)], suffixes)
+	}
+}
+
+func BenchmarkDomainMatcher10k(b *testing.B)  { benchmarkMatcher(b, 10000) }
+func BenchmarkDomainMatcher100k(b *testing.B) { benchmarkMatcher(b, 100000) }
+func BenchmarkLinearScan10k(b *testing.B)     { benchmarkLinear(b, 10000) }
+func BenchmarkLinearScan100k(b *testing.B)    { benchmarkLinear(b, 100000) }
@@ -92,6 +92,7 @@ func (pm *PersistenceManager) SaveCache(cache map[string]*CacheEntry) error {
 	defer pm.mu.Unlock()
 
 	data := map[string]interface{}{
+		"version":   cacheFormatVersion,
 		"timestamp": time.Now().Unix(),
 		"entries":   cache,
 	}
@@ -109,6 +110,7 @@ func (pm *PersistenceManager) LoadCache() (map[string]*CacheEntry, error) {
 	defer pm.mu.RUnlock()
 
 	var data struct {
+		Version   int                    `json:"version"`
 		Timestamp int64                  `json:"timestamp"`
 		Entries   map[string]*CacheEntry `json:"entries"`
 	}
@@ -117,6 +119,12 @@ func (pm *PersistenceManager) LoadCache() (map[string]*CacheEntry, error) {
 		return make(map[string]*CacheEntry), nil
 	}
 
+	// 缓存键格式不同的旧数据不会再被命中，直接丢弃
+	if data.Version != cacheFormatVersion {
+		fmt.Printf("缓存文件版本 %d 与当前版本 %d 不一致，丢弃旧缓存\n", data.Version, cacheFormatVersion)
+		return make(map[string]*CacheEntry), nil
+	}
+
 	// 清理过期缓存
 	now := time.Now()
 	validEntries := make(map[string]*CacheEntry)
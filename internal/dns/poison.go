@@ -0,0 +1,289 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gfwInjectionIPs GFW 投毒时常见的伪造应答地址
+var gfwInjectionIPs = []string{
+	"4.36.66.178", "8.7.198.45", "37.61.54.158", "46.82.174.68", "59.24.3.173",
+	"64.33.88.161", "64.33.99.47", "64.66.163.251", "65.104.202.252", "65.160.219.113",
+	"66.45.252.237", "72.14.205.99", "72.14.205.104", "78.16.49.15", "93.46.8.89",
+	"128.121.126.139", "159.106.121.75", "169.132.13.103", "192.67.198.6", "202.106.1.2",
+	"202.181.7.85", "203.98.7.65", "203.161.230.171", "207.12.88.98", "208.56.31.43",
+	"209.36.73.33", "209.145.54.50", "209.220.30.174", "211.94.66.147", "213.169.251.35",
+	"216.221.188.182", "216.234.179.13", "243.185.187.39",
+}
+
+// reservedRanges 不应出现在公网域名应答中的保留地址段
+var reservedRanges = []string{
+	"0.0.0.0/8", "127.0.0.0/8", "169.254.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fe80::/10", "ff00::/8",
+}
+
+// 候选写入频率限制：同一域名写入 GFW 学习候选的最小间隔，以及记录表超过多少条目时清理过期项
+const (
+	candidateRecordInterval = time.Minute
+	candidateRecentPrune    = 10000
+)
+
+var poisonedAnswers = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "boomdns_poisoned_answers_total",
+		Help: "Upstream answers containing poisoned addresses, by route and whether a genuine response followed",
+	},
+	[]string{"target", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(poisonedAnswers)
+}
+
+// poisonedError 应答包含投毒地址
+type poisonedError struct {
+	ip netip.Addr
+}
+
+func (e *poisonedError) Error() string {
+	return fmt.Sprintf("应答包含投毒地址 %s", e.ip)
+}
+
+// GFWCandidate china 路由被污染的域名，供 GFW 规则学习使用
+type GFWCandidate struct {
+	Domain    string    `json:"domain"`
+	Hits      int64     `json:"hits"`
+	LastIP    string    `json:"last_ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// poisonFilter 污染应答过滤器
+type poisonFilter struct {
+	ips      map[netip.Addr]bool
+	prefixes []netip.Prefix
+	routes   map[string]bool
+	wait     time.Duration
+
+	mu     sync.Mutex
+	recent map[string]time.Time // domain -> 上次写入候选的时间，限制写入频率
+}
+
+// newPoisonFilter 按配置创建过滤器，未启用时返回 nil
+func newPoisonFilter(cfg *Config) *poisonFilter {
+	pc := cfg.PoisonFilter
+	if !pc.Enabled {
+		return nil
+	}
+	p := &poisonFilter{
+		ips:    make(map[netip.Addr]bool),
+		routes: make(map[string]bool),
+		wait:   cfg.GetPoisonWait(),
+		recent: make(map[string]time.Time),
+	}
+	add := func(s string) {
+		prefix, err := parseClientPrefix(s)
+		if err != nil {
+			log.Printf("忽略无效的投毒地址 %s: %v", s, err)
+			return
+		}
+		if prefix.IsSingleIP() {
+			p.ips[prefix.Addr()] = true
+		} else {
+			p.prefixes = append(p.prefixes, prefix)
+		}
+	}
+	for _, s := range pc.BogusNXDomain {
+		add(s)
+	}
+	if !pc.DisableBuiltin {
+		for _, s := range gfwInjectionIPs {
+			add(s)
+		}
+	}
+	if !pc.AllowReserved {
+		for _, s := range reservedRanges {
+			add(s)
+		}
+	}
+	for _, r := range cfg.GetPoisonFilterRoutes() {
+		p.routes[strings.ToLower(strings.TrimSpace(r))] = true
+	}
+	return p
+}
+
+// enabledFor 判断路由是否启用过滤
+func (p *poisonFilter) enabledFor(target string) bool {
+	return p != nil && p.routes[upstreamGroupOf(target)]
+}
+
+// isPoisonIP 判断地址是否为投毒地址
+func (p *poisonFilter) isPoisonIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if p.ips[ip] {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// poisoned 返回应答中第一个投毒地址
+func (p *poisonFilter) poisoned(resp *mdns.Msg) (netip.Addr, bool) {
+	for _, rr := range resp.Answer {
+		var ip net.IP
+		switch a := rr.(type) {
+		case *mdns.A:
+			ip = a.A
+		case *mdns.AAAA:
+			ip = a.AAAA
+		default:
+			continue
+		}
+		if addr, ok := netip.AddrFromSlice(ip); ok && p.isPoisonIP(addr) {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// allowCandidate 判断域名是否可以写入候选（同一域名每 candidateRecordInterval 最多一次）。
+// 记录表超过 candidateRecentPrune 条时清理过期项，仍然过多时整体清空，最多导致个别域名提前再次写入
+func (p *poisonFilter) allowCandidate(name string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if last, ok := p.recent[name]; ok && now.Sub(last) < candidateRecordInterval {
+		return false
+	}
+	if len(p.recent) >= candidateRecentPrune {
+		for k, t := range p.recent {
+			if now.Sub(t) >= candidateRecordInterval {
+				delete(p.recent, k)
+			}
+		}
+		if len(p.recent) >= candidateRecentPrune {
+			p.recent = make(map[string]time.Time)
+		}
+	}
+	p.recent[name] = now
+	return true
+}
+
+// rejects 判断该路由是否应丢弃此应答
+func (p *poisonFilter) rejects(target string, resp *mdns.Msg) bool {
+	if !p.enabledFor(target) || resp == nil {
+		return false
+	}
+	_, bad := p.poisoned(resp)
+	return bad
+}
+
+// exchangeUDP 发送 UDP 查询；收到污染应答后在等待窗口内继续接收，
+// 抢答的伪造应答之后通常会到达真实应答
+func (p *poisonFilter) exchangeUDP(ctx context.Context, req *mdns.Msg, addr, target string) (*mdns.Msg, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, protoUDP, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	co := &mdns.Conn{Conn: conn}
+	if opt := req.IsEdns0(); opt != nil {
+		co.UDPSize = opt.UDPSize()
+	}
+	deadline, _ := ctx.Deadline()
+	_ = co.SetDeadline(deadline)
+	if err := co.WriteMsg(req); err != nil {
+		return nil, err
+	}
+
+	var first *mdns.Msg
+	for {
+		resp, err := co.ReadMsg()
+		if err != nil {
+			if first != nil {
+				return first, nil
+			}
+			return nil, err
+		}
+		if resp.Id != req.Id || len(resp.Question) != 1 || !strings.EqualFold(resp.Question[0].Name, req.Question[0].Name) {
+			continue
+		}
+		if _, bad := p.poisoned(resp); !bad {
+			if first != nil {
+				poisonedAnswers.WithLabelValues(target, "recovered").Inc()
+			}
+			return resp, nil
+		}
+		if first == nil {
+			first = resp
+			if until := time.Now().Add(p.wait); until.Before(deadline) {
+				_ = co.SetReadDeadline(until)
+			}
+		}
+	}
+}
+
+// exchangeFiltered 启用等待窗口的路由通过 UDP 上游查询时接收多个应答，其余情况直接查询
+func (s *Server) exchangeFiltered(ctx context.Context, req *mdns.Msg, u upstream, target string) (*mdns.Msg, error) {
+	if u.Proto == protoUDP && s.poison.enabledFor(target) && s.poison.wait > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, defaultUpstreamTimeout)
+			defer cancel()
+		}
+		return s.poison.exchangeUDP(ctx, req, u.Endpoint, target)
+	}
+	return s.exchange(ctx, req, u)
+}
+
+// checkPoisoned 过滤阶段：应答包含投毒地址时返回 poisonedError
+func (s *Server) checkPoisoned(resp *mdns.Msg, target string) error {
+	if !s.poison.enabledFor(target) {
+		return nil
+	}
+	if ip, bad := s.poison.poisoned(resp); bad {
+		poisonedAnswers.WithLabelValues(target, "filtered").Inc()
+		return &poisonedError{ip: ip}
+	}
+	return nil
+}
+
+// recordGFWCandidate china 路由被污染时将域名记录为 GFW 学习候选（异步写入 SQLite）
+func (s *Server) recordGFWCandidate(name string, ip netip.Addr) {
+	if !s.poison.allowCandidate(name, time.Now()) {
+		return
+	}
+	log.Printf("china 上游应答被污染 %s (%s)，改走 intl", name, ip)
+
+	sm, ok := s.persistence.(*SQLiteManager)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := sm.RecordGFWCandidate(name, ip.String()); err != nil {
+			log.Printf("记录 GFW 学习候选失败: %v", err)
+		}
+	}()
+}
+
+// GetGFWCandidates 返回 GFW 学习候选，按命中次数降序
+func (s *Server) GetGFWCandidates(limit int) ([]GFWCandidate, error) {
+	sm, ok := s.persistence.(*SQLiteManager)
+	if !ok {
+		return nil, fmt.Errorf("GFW 学习候选需要启用 SQLite 存储")
+	}
+	return sm.GetGFWCandidates(limit)
+}
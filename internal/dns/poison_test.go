@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// startRacingUpstream 模拟 GFW 抢答的 UDP 上游：对每个查询先返回 ID 不符的应答与投毒应答，
+// delay 后再返回真实应答
+func startRacingUpstream(t *testing.T, delay time.Duration) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	reply := func(req *mdns.Msg, id uint16, ip string) []byte {
+		m := new(mdns.Msg)
+		m.SetReply(req)
+		m.Id = id
+		m.Answer = append(m.Answer, &mdns.A{
+			Hdr: mdns.RR_Header{Name: req.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(ip),
+		})
+		wire, err := m.Pack()
+		if err != nil {
+			panic(err)
+		}
+		return wire
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := new(mdns.Msg)
+			if err := req.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(reply(req, req.Id+1, "192.0.2.200"), addr)
+			_, _ = pc.WriteTo(reply(req, req.Id, "8.7.198.45"), addr)
+			go func() {
+				time.Sleep(delay)
+				_, _ = pc.WriteTo(reply(req, req.Id, "192.0.2.1"), addr)
+			}()
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func newTestPoisonFilter(wait time.Duration) *poisonFilter {
+	cfg := &Config{}
+	cfg.PoisonFilter.Enabled = true
+	cfg.PoisonFilter.WaitMs = int(wait / time.Millisecond)
+	cfg.PoisonFilter.Routes = []string{"china"}
+	return newPoisonFilter(cfg)
+}
+
+func TestPoisonWaitForGenuineReply(t *testing.T) {
+	cases := []struct {
+		name       string
+		delay      time.Duration
+		wait       time.Duration
+		want       string
+		maxElapsed time.Duration
+	}{
+		// 真实应答在等待窗口内到达，收到后立即返回
+		{"recovered", 20 * time.Millisecond, time.Second, "192.0.2.1", 500 * time.Millisecond},
+		// 等待窗口结束仍未收到真实应答，返回污染应答交由过滤阶段处理
+		{"window expired", time.Second, 50 * time.Millisecond, "8.7.198.45", 500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr := startRacingUpstream(t, c.delay)
+			p := newTestPoisonFilter(c.wait)
+
+			req := new(mdns.Msg)
+			req.SetQuestion("www.google.com.", mdns.TypeA)
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			start := time.Now()
+			resp, err := p.exchangeUDP(ctx, req, addr, "china")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Answer) != 1 {
+				t.Fatalf("answer = %v", resp.Answer)
+			}
+			if got := resp.Answer[0].(*mdns.A).A.String(); got != c.want {
+				t.Errorf("answer = %s, want %s", got, c.want)
+			}
+			if elapsed := time.Since(start); elapsed > c.maxElapsed {
+				t.Errorf("took %v, want <= %v", elapsed, c.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestPoisonCandidateRateLimit(t *testing.T) {
+	p := newTestPoisonFilter(0)
+	now := time.Now()
+
+	if !p.allowCandidate("example.com", now) {
+		t.Fatal("first record should be allowed")
+	}
+	if p.allowCandidate("example.com", now.Add(time.Second)) {
+		t.Error("second record within the interval should be suppressed")
+	}
+	if !p.allowCandidate("example.com", now.Add(candidateRecordInterval)) {
+		t.Error("record after the interval should be allowed")
+	}
+
+	// 记录表达到上限时清理过期项
+	for i := 0; i < candidateRecentPrune; i++ {
+		p.allowCandidate(fmt.Sprintf("old%d.example", i), now)
+	}
+	later := now.Add(2 * candidateRecordInterval)
+	if !p.allowCandidate("new.example", later) {
+		t.Fatal("new domain should be allowed")
+	}
+	if n := len(p.recent); n > 2 {
+		t.Errorf("len(recent) = %d after prune, want <= 2", n)
+	}
+
+	// 全部未过期时整体清空，表的大小仍受限
+	for i := 0; i < 2*candidateRecentPrune; i++ {
+		p.allowCandidate(fmt.Sprintf("burst%d.example", i), later)
+	}
+	if n := len(p.recent); n > candidateRecentPrune {
+		t.Errorf("len(recent) = %d, want <= %d", n, candidateRecentPrune)
+	}
+}
@@ -85,6 +85,8 @@ type ProxyRule struct {
 	Enabled    bool   `json:"enabled"`
 	CreatedAt  int64  `json:"created_at"`
 	UpdatedAt  int64  `json:"updated_at"`
+
+	domain *DomainRule // domain 类型规则解析后的结果，由 AddRule 填充
 }
 
 // ProxyManager 代理管理器
@@ -322,6 +324,14 @@ func (pm *ProxyManager) GetGroup(name string) *ProxyGroup {
 
 // AddRule 添加代理规则
 func (pm *ProxyManager) AddRule(rule *ProxyRule) error {
+	if rule.Type == "domain" {
+		parsed, err := ParseDomainRule(rule.Value)
+		if err != nil {
+			return err
+		}
+		rule.domain = &parsed
+	}
+
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
@@ -350,7 +360,7 @@ func (pm *ProxyManager) MatchRule(domain string, ip net.IP) (string, string) {
 
 		switch rule.Type {
 		case "domain":
-			if pm.matchDomain(domain, rule.Value) {
+			if pm.matchDomain(domain, rule) {
 				return rule.Action, rule.ProxyGroup
 			}
 		case "ip-cidr":
@@ -536,23 +546,13 @@ func (pm *ProxyManager) selectNode(group *ProxyGroup) int {
 }
 
 // matchDomain 匹配域名规则
-func (pm *ProxyManager) matchDomain(domain, pattern string) bool {
-	// 简单的域名匹配，支持通配符
-	if pattern == "" || domain == "" {
+func (pm *ProxyManager) matchDomain(domain string, rule *ProxyRule) bool {
+	if rule.domain == nil || domain == "" {
 		return false
 	}
 
-	// 精确匹配
-	if pattern == domain {
-		return true
-	}
-
-	// 通配符匹配
-	if len(pattern) > 1 && pattern[0] == '.' {
-		return len(domain) >= len(pattern) && domain[len(domain)-len(pattern):] == pattern
-	}
-
-	return false
+	// 与 DNS 分流使用同一套规则语法：full: / domain: / keyword: / regexp: / *.example.com
+	return rule.domain.Match(domain)
 }
 
 // matchIPCIDR 匹配IP CIDR规则
@@ -0,0 +1,275 @@
+package dns
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	bucketIdleTimeout = time.Minute // 令牌桶空闲多久后回收
+	maxBuckets        = 100000      // 每个限速器最多维护的令牌桶数
+	bucketSweepEvery  = time.Second // 桶表已满时回收已补满令牌的桶的最小间隔
+)
+
+var (
+	rateLimitDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_ratelimit_dropped_total",
+			Help: "Queries or responses dropped by rate limiting",
+		},
+		[]string{"kind"},
+	)
+	rateLimitSlipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_ratelimit_slipped_total",
+			Help: "Truncated (TC=1) responses sent instead of dropping",
+		},
+		[]string{"kind"},
+	)
+	rateLimitRefused = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_ratelimit_refused_total",
+			Help: "Queries refused by rate limiting on stream transports",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitDropped, rateLimitSlipped, rateLimitRefused)
+}
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	limited uint64 // 超限次数，slip 按桶计数
+}
+
+// bucketLimiter 按键维护令牌桶
+type bucketLimiter struct {
+	rate  float64 // 每秒补充的令牌数
+	burst float64
+
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	maxBuckets int
+	swept      time.Time
+	overflow   uint64 // 桶表已满时拒绝新键的次数，slip 按此计数
+}
+
+func newBucketLimiter(rate, burst int) *bucketLimiter {
+	if burst < rate {
+		burst = rate
+	}
+	return &bucketLimiter{
+		rate:       float64(rate),
+		burst:      float64(burst),
+		buckets:    make(map[string]*tokenBucket),
+		maxBuckets: maxBuckets,
+	}
+}
+
+// allow 消耗一个令牌，令牌不足时返回 false 及该桶累计的超限次数。
+// 桶表已满时先回收令牌已补满的桶（与新建的桶等价），仍然已满则新键按超限处理，
+// 随机查询名或伪造的源地址段无法无限制地占用内存
+func (l *bucketLimiter) allow(key string, now time.Time) (bool, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= l.maxBuckets {
+			if now.Sub(l.swept) >= bucketSweepEvery {
+				l.swept = now
+				for k, old := range l.buckets {
+					if old.tokens+now.Sub(old.last).Seconds()*l.rate >= l.burst {
+						delete(l.buckets, k)
+					}
+				}
+			}
+			if len(l.buckets) >= l.maxBuckets {
+				l.overflow++
+				return false, l.overflow
+			}
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		b.limited++
+		return false, b.limited
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup 回收长时间未使用的令牌桶
+func (l *bucketLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimiter 客户端查询限速与响应限速 (RRL)
+//
+// 查询按客户端前缀计数；响应按 (客户端前缀, 查询名) 计数，仅对 UDP 生效。
+// UDP 超限时每个桶每 slip 次中有一次返回 TC=1 的截断应答，促使正常客户端改用 TCP，其余直接丢弃；
+// 流式传输（TCP / DoT / DoH / DoQ）的源地址已验证，超限时返回 REFUSED。
+type rateLimiter struct {
+	queries   *bucketLimiter // 为 nil 表示不限制查询
+	responses *bucketLimiter // 为 nil 表示不限制响应
+	slip      uint64
+	v4Bits    int
+	v6Bits    int
+	exempt    []netip.Prefix
+}
+
+// newRateLimiter 按配置创建限速器，未启用时返回 nil
+func newRateLimiter(cfg *Config) *rateLimiter {
+	qps, rps := cfg.Security.RateLimit, cfg.Security.ResponsesPerSecond
+	if qps <= 0 && rps <= 0 {
+		return nil
+	}
+	l := &rateLimiter{
+		slip:   uint64(cfg.GetRateLimitSlip()),
+		v4Bits: cfg.GetRateLimitIPv4Prefix(),
+		v6Bits: cfg.GetRateLimitIPv6Prefix(),
+	}
+	if qps > 0 {
+		l.queries = newBucketLimiter(qps, cfg.Security.RateLimitBurst)
+	}
+	if rps > 0 {
+		l.responses = newBucketLimiter(rps, rps)
+	}
+	for _, e := range cfg.Security.Exempt {
+		if p, err := parseClientPrefix(e); err == nil {
+			l.exempt = append(l.exempt, p)
+		}
+	}
+	go l.cleanupLoop()
+	return l
+}
+
+func (l *rateLimiter) cleanupLoop() {
+	ticker := time.NewTicker(bucketIdleTimeout)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if l.queries != nil {
+			l.queries.cleanup(now)
+		}
+		if l.responses != nil {
+			l.responses.cleanup(now)
+		}
+	}
+}
+
+// admit 检查查询限速，通过时返回包装了响应限速的 ResponseWriter；
+// 未通过时已按 slip / drop / refuse 处理，调用方应直接返回
+func (l *rateLimiter) admit(w mdns.ResponseWriter, r *mdns.Msg) (mdns.ResponseWriter, bool) {
+	if l == nil {
+		return w, true
+	}
+	ip, ok := addrIP(w.RemoteAddr())
+	if !ok {
+		return w, true
+	}
+	for _, p := range l.exempt {
+		if p.Contains(ip) {
+			return w, true
+		}
+	}
+
+	client := l.clientKey(ip)
+	udp := isUDPWriter(w)
+	now := time.Now()
+
+	if l.queries != nil {
+		if ok, limited := l.queries.allow(client, now); !ok {
+			l.reject(w, r, udp, "query", limited)
+			return nil, false
+		}
+	}
+	if l.responses == nil || !udp {
+		return w, true
+	}
+	return &rrlWriter{ResponseWriter: w, limiter: l, req: r, client: client}, true
+}
+
+// reject 超限处理：UDP 按桶的超限次数 limited 每 slip 次截断一次，其余丢弃；流式传输返回 REFUSED
+func (l *rateLimiter) reject(w mdns.ResponseWriter, r *mdns.Msg, udp bool, kind string, limited uint64) {
+	if !udp {
+		m := new(mdns.Msg)
+		m.SetRcode(r, mdns.RcodeRefused)
+		_ = w.WriteMsg(m)
+		rateLimitRefused.WithLabelValues(kind).Inc()
+		return
+	}
+	if l.slip > 0 && limited%l.slip == 0 {
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		m.Truncated = true
+		_ = w.WriteMsg(m)
+		rateLimitSlipped.WithLabelValues(kind).Inc()
+		return
+	}
+	rateLimitDropped.WithLabelValues(kind).Inc()
+}
+
+// clientKey 将客户端地址归并到配置的前缀
+func (l *rateLimiter) clientKey(ip netip.Addr) string {
+	bits := l.v6Bits
+	if ip.Is4() {
+		bits = l.v4Bits
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return ip.String()
+	}
+	return p.String()
+}
+
+// isUDPWriter 判断是否为 UDP 传输（DoQ 虽然基于 UDP，但地址已由 QUIC 握手验证）
+func isUDPWriter(w mdns.ResponseWriter) bool {
+	if _, ok := w.(*msgWriter); ok {
+		return false
+	}
+	_, ok := w.RemoteAddr().(*net.UDPAddr)
+	return ok
+}
+
+// rrlWriter 在写出响应前按 (客户端前缀, 查询名) 限速
+type rrlWriter struct {
+	mdns.ResponseWriter
+	limiter *rateLimiter
+	req     *mdns.Msg
+	client  string
+}
+
+func (w *rrlWriter) WriteMsg(m *mdns.Msg) error {
+	qname := ""
+	if len(m.Question) > 0 {
+		qname = strings.ToLower(m.Question[0].Name)
+	}
+	if ok, limited := w.limiter.responses.allow(w.client+"|"+qname, time.Now()); !ok {
+		w.limiter.reject(w.ResponseWriter, w.req, true, "response", limited)
+		return nil
+	}
+	return w.ResponseWriter.WriteMsg(m)
+}
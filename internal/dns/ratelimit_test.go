@@ -0,0 +1,195 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// recordWriter 记录写出的应答
+type recordWriter struct {
+	remote net.Addr
+	msgs   []*mdns.Msg
+}
+
+func (w *recordWriter) LocalAddr() net.Addr         { return &net.UDPAddr{Port: 53} }
+func (w *recordWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *recordWriter) WriteMsg(m *mdns.Msg) error  { w.msgs = append(w.msgs, m); return nil }
+func (w *recordWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *recordWriter) Close() error                { return nil }
+func (w *recordWriter) TsigStatus() error           { return nil }
+func (w *recordWriter) TsigTimersOnly(bool)         {}
+func (w *recordWriter) Hijack()                     {}
+
+func newTestRateLimiter(qps, rps, slip int, exempt ...string) *rateLimiter {
+	cfg := &Config{}
+	cfg.Security.RateLimit = qps
+	cfg.Security.ResponsesPerSecond = rps
+	cfg.Security.Slip = slip
+	cfg.Security.Exempt = exempt
+	return newRateLimiter(cfg)
+}
+
+func testQuery(name string) *mdns.Msg {
+	r := new(mdns.Msg)
+	r.SetQuestion(name, mdns.TypeA)
+	return r
+}
+
+func TestBucketLimiterAllow(t *testing.T) {
+	l := newBucketLimiter(2, 4)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		if ok, _ := l.allow("a", now); !ok {
+			t.Fatalf("query %d within burst limited", i)
+		}
+	}
+	for i := uint64(1); i <= 3; i++ {
+		if ok, limited := l.allow("a", now); ok || limited != i {
+			t.Errorf("allow = %v %d, want false %d", ok, limited, i)
+		}
+	}
+	// 其他键不受影响
+	if ok, _ := l.allow("b", now); !ok {
+		t.Error("independent key limited")
+	}
+	// 按速率补充令牌
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("token not refilled after 0.5s at 2/s")
+	}
+	if ok, _ := l.allow("a", now.Add(500*time.Millisecond)); ok {
+		t.Error("refill exceeded rate")
+	}
+}
+
+func TestBucketLimiterCap(t *testing.T) {
+	l := newBucketLimiter(1, 1)
+	l.maxBuckets = 10
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		l.allow(fmt.Sprintf("k%d", i), now)
+	}
+	// 桶表已满且没有可回收的桶：新键按超限处理，已有的键不受影响
+	for i := uint64(1); i <= 3; i++ {
+		if ok, limited := l.allow(fmt.Sprintf("new%d", i), now); ok || limited != i {
+			t.Errorf("allow(new) = %v %d, want false %d", ok, limited, i)
+		}
+	}
+	if len(l.buckets) != 10 {
+		t.Errorf("len(buckets) = %d, want 10", len(l.buckets))
+	}
+	// 令牌补满的桶可回收
+	later := now.Add(2 * time.Second)
+	if ok, _ := l.allow("fresh", later); !ok {
+		t.Error("new key limited after buckets refilled")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("len(buckets) = %d after sweep, want 1", len(l.buckets))
+	}
+}
+
+func TestRateLimitSlip(t *testing.T) {
+	l := newTestRateLimiter(0, 1, 2)
+	w := &recordWriter{remote: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}}
+	r := testQuery("example.com.")
+
+	var truncated, answered int
+	for i := 0; i < 7; i++ {
+		rw, ok := l.admit(w, r)
+		if !ok {
+			t.Fatal("query limiting disabled but query rejected")
+		}
+		n := len(w.msgs)
+		reply := new(mdns.Msg)
+		reply.SetReply(r)
+		_ = rw.WriteMsg(reply)
+		if len(w.msgs) == n {
+			continue
+		}
+		if w.msgs[n].Truncated {
+			truncated++
+		} else {
+			answered++
+		}
+	}
+	// 1 次正常应答，其余 6 次超限中每 2 次截断一次，其余丢弃
+	if answered != 1 || truncated != 3 {
+		t.Errorf("answered %d truncated %d, want 1 and 3", answered, truncated)
+	}
+
+	// 不同查询名使用独立的桶
+	rw, _ := l.admit(w, testQuery("other.example."))
+	reply := new(mdns.Msg)
+	reply.SetReply(testQuery("other.example."))
+	n := len(w.msgs)
+	_ = rw.WriteMsg(reply)
+	if len(w.msgs) != n+1 || w.msgs[n].Truncated {
+		t.Error("response for another name limited")
+	}
+}
+
+func TestRateLimitRefuseStream(t *testing.T) {
+	l := newTestRateLimiter(1, 1, 2)
+	w := &recordWriter{remote: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}}
+	r := testQuery("example.com.")
+
+	if _, ok := l.admit(w, r); !ok {
+		t.Fatal("first query rejected")
+	}
+	if len(w.msgs) != 0 {
+		t.Fatal("admitted query wrote a response")
+	}
+	// 流式传输超限返回 REFUSED，不截断也不丢弃
+	for i := 0; i < 3; i++ {
+		if _, ok := l.admit(w, r); ok {
+			t.Fatal("query over limit admitted")
+		}
+	}
+	if len(w.msgs) != 3 {
+		t.Fatalf("wrote %d responses, want 3", len(w.msgs))
+	}
+	for _, m := range w.msgs {
+		if m.Rcode != mdns.RcodeRefused || m.Truncated {
+			t.Errorf("response = %s tc=%v, want REFUSED", mdns.RcodeToString[m.Rcode], m.Truncated)
+		}
+	}
+	// 响应限速只用于 UDP
+	if rw, _ := newTestRateLimiter(0, 1, 2).admit(w, r); rw != mdns.ResponseWriter(w) {
+		t.Error("response limiting applied to stream transport")
+	}
+}
+
+func TestRateLimitExempt(t *testing.T) {
+	l := newTestRateLimiter(1, 1, 2, "192.0.2.0/24", "2001:db8::1")
+	cases := []struct {
+		addr   net.IP
+		exempt bool
+	}{
+		{net.ParseIP("192.0.2.10"), true},
+		{net.ParseIP("2001:db8::1"), true},
+		{net.ParseIP("::ffff:192.0.2.20"), true}, // IPv4 映射地址按 IPv4 匹配
+		{net.ParseIP("198.51.100.1"), false},
+		{net.ParseIP("2001:db8::2"), false},
+	}
+	for _, c := range cases {
+		w := &recordWriter{remote: &net.UDPAddr{IP: c.addr, Port: 5353}}
+		admitted := 0
+		for i := 0; i < 5; i++ {
+			if rw, ok := l.admit(w, testQuery("example.com.")); ok {
+				admitted++
+				if c.exempt && rw != mdns.ResponseWriter(w) {
+					t.Errorf("%s: exempt client got response limiting", c.addr)
+				}
+			}
+		}
+		if c.exempt && admitted != 5 {
+			t.Errorf("%s: admitted %d of 5, want all", c.addr, admitted)
+		}
+		if !c.exempt && admitted != 1 {
+			t.Errorf("%s: admitted %d of 5, want 1", c.addr, admitted)
+		}
+	}
+}
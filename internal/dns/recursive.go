@@ -0,0 +1,407 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RecursiveUpstream 内置递归解析在上游列表中的地址
+const RecursiveUpstream = "recursive"
+
+// defaultRootHints IANA 根服务器 IPv4 地址（a ~ m）
+var defaultRootHints = []string{
+	"198.41.0.4", "170.247.170.2", "192.33.4.12", "199.7.91.13", "192.203.230.10",
+	"192.5.5.241", "192.112.36.4", "198.97.190.53", "192.36.148.17", "192.58.128.30",
+	"193.0.14.129", "199.7.83.42", "202.12.27.33",
+}
+
+// 递归解析的限制
+const (
+	recursiveMaxDepth     = 8                       // CNAME 追踪与无 glue 的 NS 地址解析的最大嵌套层数
+	recursiveMaxSteps     = 32                      // 单次解析最多的迭代查询轮数
+	recursiveHopTimeout   = 1500 * time.Millisecond // 单个权威服务器的查询超时
+	recursiveMaxNSTTL     = 24 * time.Hour
+	recursiveNSCachePrune = 10000 // NS 缓存超过该条目数时清理过期项
+)
+
+var recursiveQueries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "boomdns_recursive_queries_total",
+		Help: "Iterative queries sent to authoritative servers by the built-in recursive resolver",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(recursiveQueries)
+}
+
+// nsEntry 区的权威服务器地址
+type nsEntry struct {
+	addrs    []string
+	expireAt time.Time
+}
+
+// recursor 内置递归解析器：从根提示开始逐级跟随委派，支持 QNAME 最小化 (RFC 9156)，
+// 委派信息保存在独立的 NS 缓存中
+type recursor struct {
+	hints    []string
+	port     string
+	minimise bool
+
+	mu sync.Mutex
+	ns map[string]*nsEntry
+}
+
+// newRecursor 按配置创建递归解析器，未启用时返回 nil
+func newRecursor(cfg *Config) *recursor {
+	if !cfg.Upstreams.Recursive.Enabled {
+		return nil
+	}
+	r := &recursor{
+		port:     strconv.Itoa(cfg.GetRecursivePort()),
+		minimise: !cfg.Upstreams.Recursive.DisableQnameMinimisation,
+		ns:       make(map[string]*nsEntry),
+	}
+	for _, h := range cfg.GetRootHints() {
+		r.hints = append(r.hints, withDefaultPort(h, r.port))
+	}
+	return r
+}
+
+// exchange 递归解析请求并构造应答
+func (r *recursor) exchange(ctx context.Context, req *mdns.Msg) (*mdns.Msg, error) {
+	if len(req.Question) == 0 {
+		return nil, fmt.Errorf("请求缺少问题")
+	}
+	q := req.Question[0]
+	do := false
+	opt := req.IsEdns0()
+	if opt != nil {
+		do = opt.Do()
+	}
+	res, err := r.resolve(ctx, mdns.CanonicalName(q.Name), q.Qtype, do, 0)
+	if err != nil {
+		return nil, err
+	}
+	m := new(mdns.Msg)
+	m.SetReply(req)
+	m.RecursionAvailable = true
+	m.Rcode = res.Rcode
+	m.Answer = res.Answer
+	m.Ns = res.Ns
+	if opt != nil {
+		m.SetEdns0(opt.UDPSize(), do)
+	}
+	return m, nil
+}
+
+// resolve 从已知最近的区开始迭代查询。开启 QNAME 最小化时每次只向权威服务器暴露比当前区多一个标签的名称
+func (r *recursor) resolve(ctx context.Context, qname string, qtype uint16, do bool, depth int) (*mdns.Msg, error) {
+	if depth > recursiveMaxDepth {
+		return nil, fmt.Errorf("递归解析 %s 层数过深", qname)
+	}
+	zone, servers := r.closestZone(qname, qtype)
+	labels := mdns.CountLabel(qname)
+	n := mdns.CountLabel(zone) + 1
+	minimise := r.minimise
+
+	for step := 0; step < recursiveMaxSteps; step++ {
+		name, t := qname, qtype
+		if minimise && n < labels {
+			// 中间步骤按 RFC 9156 建议使用 A 类型查询
+			name, t = lastLabels(qname, n), mdns.TypeA
+		}
+		resp, err := r.query(ctx, servers, name, t, do)
+		if err != nil {
+			return nil, err
+		}
+
+		if child, nsNames, ok := referral(resp, zone, name); ok {
+			addrs, ttl := r.glue(resp, zone, nsNames)
+			if len(addrs) == 0 {
+				addrs = r.resolveNS(ctx, nsNames, depth)
+			}
+			if len(addrs) == 0 {
+				return nil, fmt.Errorf("无法解析 %s 的权威服务器", child)
+			}
+			r.store(child, addrs, ttl)
+			zone, servers = child, addrs
+			n = mdns.CountLabel(zone) + 1
+			continue
+		}
+
+		if name != qname {
+			// 部分权威服务器对空非终端名称返回 NXDOMAIN，此时改为查询完整名称
+			if resp.Rcode == mdns.RcodeNameError {
+				minimise = false
+			} else {
+				n++
+			}
+			continue
+		}
+		return r.followCNAME(ctx, inBailiwick(resp, zone, qname), qname, qtype, do, depth)
+	}
+	return nil, fmt.Errorf("递归解析 %s 迭代次数过多", qname)
+}
+
+// closestZone 返回 NS 缓存中距离 qname 最近的区；DS 记录由父区应答，从父区开始查找
+func (r *recursor) closestZone(qname string, qtype uint16) (string, []string) {
+	name := qname
+	if qtype == mdns.TypeDS && name != "." {
+		name = parentName(name)
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name != "." {
+		if e := r.ns[name]; e != nil && now.Before(e.expireAt) {
+			return name, e.addrs
+		}
+		name = parentName(name)
+	}
+	return ".", r.hints
+}
+
+// store 缓存区的权威服务器地址
+func (r *recursor) store(zone string, addrs []string, ttl time.Duration) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.ns) > recursiveNSCachePrune {
+		for k, e := range r.ns {
+			if !now.Before(e.expireAt) {
+				delete(r.ns, k)
+			}
+		}
+	}
+	r.ns[zone] = &nsEntry{addrs: addrs, expireAt: now.Add(ttl)}
+}
+
+// query 依次向区的权威服务器发送非递归查询，返回第一个有效应答
+func (r *recursor) query(ctx context.Context, servers []string, name string, qtype uint16, do bool) (*mdns.Msg, error) {
+	m := new(mdns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(1232, do)
+
+	var lastErr error
+	for _, addr := range servers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := r.exchangeOne(ctx, m, addr)
+		if err == nil && (resp.Rcode == mdns.RcodeServerFailure || resp.Rcode == mdns.RcodeRefused) {
+			err = fmt.Errorf("%s 返回 %s", addr, mdns.RcodeToString[resp.Rcode])
+		}
+		if err != nil {
+			recursiveQueries.WithLabelValues("failure").Inc()
+			lastErr = err
+			continue
+		}
+		recursiveQueries.WithLabelValues("success").Inc()
+		return resp, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("没有可用的权威服务器")
+	}
+	return nil, fmt.Errorf("查询 %s %s 失败: %w", name, mdns.TypeToString[qtype], lastErr)
+}
+
+// exchangeOne 向单个权威服务器发送查询，UDP 应答被截断时改用 TCP
+func (r *recursor) exchangeOne(ctx context.Context, m *mdns.Msg, addr string) (*mdns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, recursiveHopTimeout)
+	defer cancel()
+	c := &mdns.Client{Net: protoUDP, Timeout: recursiveHopTimeout}
+	resp, _, err := c.ExchangeContext(ctx, m, addr)
+	if err == nil && resp.Truncated {
+		c.Net = protoTCP
+		resp, _, err = c.ExchangeContext(ctx, m, addr)
+	}
+	return resp, err
+}
+
+// referral 判断应答是否为指向 zone 下级区的委派，返回子区与其 NS 名称
+func referral(resp *mdns.Msg, zone, name string) (string, []string, bool) {
+	if resp.Rcode != mdns.RcodeSuccess || len(resp.Answer) > 0 || findSOA(resp.Ns) != nil {
+		return "", nil, false
+	}
+	child := ""
+	var names []string
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*mdns.NS)
+		if !ok {
+			continue
+		}
+		owner := mdns.CanonicalName(ns.Hdr.Name)
+		if child == "" {
+			child = owner
+		}
+		if owner == child {
+			names = append(names, mdns.CanonicalName(ns.Ns))
+		}
+	}
+	if child == "" || !isProperAncestor(zone, child) || !mdns.IsSubDomain(child, name) {
+		return "", nil, false
+	}
+	return child, names, true
+}
+
+// glue 从附加区提取 NS 的地址，只接受位于当前区内的 glue，IPv4 优先
+func (r *recursor) glue(resp *mdns.Msg, zone string, nsNames []string) ([]string, time.Duration) {
+	wanted := make(map[string]bool, len(nsNames))
+	for _, n := range nsNames {
+		wanted[n] = true
+	}
+	ttl := recursiveMaxNSTTL
+	for _, rr := range resp.Ns {
+		if t := time.Duration(rr.Header().Ttl) * time.Second; rr.Header().Rrtype == mdns.TypeNS && t < ttl {
+			ttl = t
+		}
+	}
+	var v4, v6 []string
+	for _, rr := range resp.Extra {
+		owner := mdns.CanonicalName(rr.Header().Name)
+		if !wanted[owner] || !mdns.IsSubDomain(zone, owner) {
+			continue
+		}
+		switch a := rr.(type) {
+		case *mdns.A:
+			v4 = append(v4, net.JoinHostPort(a.A.String(), r.port))
+		case *mdns.AAAA:
+			v6 = append(v6, net.JoinHostPort(a.AAAA.String(), r.port))
+		}
+	}
+	return append(v4, v6...), ttl
+}
+
+// resolveNS 委派未附带 glue 时递归解析 NS 名称的地址
+func (r *recursor) resolveNS(ctx context.Context, names []string, depth int) []string {
+	for _, name := range names {
+		resp, err := r.resolve(ctx, name, mdns.TypeA, false, depth+1)
+		if err != nil {
+			continue
+		}
+		var addrs []string
+		for _, rr := range resp.Answer {
+			if a, ok := rr.(*mdns.A); ok {
+				addrs = append(addrs, net.JoinHostPort(a.A.String(), r.port))
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs
+		}
+	}
+	return nil
+}
+
+// inBailiwick 过滤权威应答，防止缓存投毒：Answer 只保留 qname 及其在区内的 CNAME 链上的记录
+// （含覆盖这些名称的 DNAME 与签名），Ns 只保留区内的记录。链离开区后的记录丢弃，由 followCNAME 重新解析
+func inBailiwick(resp *mdns.Msg, zone, qname string) *mdns.Msg {
+	chain := make(map[string]bool)
+	name := qname
+	for mdns.IsSubDomain(zone, name) && !chain[name] {
+		chain[name] = true
+		next := ""
+		for _, rr := range resp.Answer {
+			if c, ok := rr.(*mdns.CNAME); ok && mdns.CanonicalName(c.Hdr.Name) == name {
+				next = mdns.CanonicalName(c.Target)
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+
+	onChain := func(rr mdns.RR) bool {
+		owner := mdns.CanonicalName(rr.Header().Name)
+		if chain[owner] {
+			return true
+		}
+		dname := rr.Header().Rrtype == mdns.TypeDNAME
+		if sig, ok := rr.(*mdns.RRSIG); ok {
+			dname = sig.TypeCovered == mdns.TypeDNAME
+		}
+		if !dname || !mdns.IsSubDomain(zone, owner) {
+			return false
+		}
+		for n := range chain {
+			if isProperAncestor(owner, n) {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := *resp
+	out.Answer = nil
+	for _, rr := range resp.Answer {
+		if onChain(rr) {
+			out.Answer = append(out.Answer, rr)
+		}
+	}
+	out.Ns = nil
+	for _, rr := range resp.Ns {
+		if mdns.IsSubDomain(zone, mdns.CanonicalName(rr.Header().Name)) {
+			out.Ns = append(out.Ns, rr)
+		}
+	}
+	return &out
+}
+
+// followCNAME 应答只包含指向其他区的 CNAME 时继续解析链尾名称，合并应答
+func (r *recursor) followCNAME(ctx context.Context, resp *mdns.Msg, qname string, qtype uint16, do bool, depth int) (*mdns.Msg, error) {
+	if qtype == mdns.TypeCNAME || resp.Rcode != mdns.RcodeSuccess {
+		return resp, nil
+	}
+	target := qname
+	for range resp.Answer {
+		next := ""
+		for _, rr := range resp.Answer {
+			if c, ok := rr.(*mdns.CNAME); ok && mdns.CanonicalName(c.Hdr.Name) == target {
+				next = mdns.CanonicalName(c.Target)
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		target = next
+	}
+	if target == qname {
+		return resp, nil
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype && mdns.CanonicalName(rr.Header().Name) == target {
+			return resp, nil
+		}
+	}
+
+	next, err := r.resolve(ctx, target, qtype, do, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	out := resp.Copy()
+	out.Answer = append(out.Answer, next.Answer...)
+	out.Ns = next.Ns
+	out.Rcode = next.Rcode
+	return out, nil
+}
+
+// lastLabels 返回名称最右侧的 n 个标签
+func lastLabels(name string, n int) string {
+	idx := mdns.Split(name)
+	if n >= len(idx) {
+		return name
+	}
+	return name[idx[len(idx)-n]:]
+}
@@ -0,0 +1,297 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// authZone 测试用的权威区
+type authZone struct {
+	name        string
+	records     []mdns.RR
+	delegations map[string][]mdns.RR // 子区 -> NS 与 glue 记录
+	entNXDOMAIN bool                 // 对空非终端名称返回 NXDOMAIN（模拟不规范的权威服务器）
+	inject      []mdns.RR            // 附加到每个应答 Answer 中的记录（模拟投毒的权威服务器）
+}
+
+// authServer 托管一个或多个区的权威服务器
+type authServer struct {
+	zones   []*authZone
+	queries atomic.Int64
+}
+
+// serve 按区数据应答：委派、精确匹配（含 CNAME）、NODATA 或 NXDOMAIN
+func (a *authServer) serve(w mdns.ResponseWriter, r *mdns.Msg) {
+	a.queries.Add(1)
+	q := r.Question[0]
+	name := mdns.CanonicalName(q.Name)
+	m := new(mdns.Msg)
+	m.SetReply(r)
+
+	var zone *authZone
+	for _, z := range a.zones {
+		if mdns.IsSubDomain(z.name, name) && (zone == nil || mdns.CountLabel(z.name) > mdns.CountLabel(zone.name)) {
+			zone = z
+		}
+	}
+	if zone == nil {
+		m.Rcode = mdns.RcodeRefused
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	for child, rrs := range zone.delegations {
+		if mdns.IsSubDomain(child, name) {
+			for _, rr := range rrs {
+				if rr.Header().Rrtype == mdns.TypeNS {
+					m.Ns = append(m.Ns, rr)
+				} else {
+					m.Extra = append(m.Extra, rr)
+				}
+			}
+			_ = w.WriteMsg(m)
+			return
+		}
+	}
+
+	m.Authoritative = true
+	exists := false
+	for _, rr := range zone.records {
+		owner := mdns.CanonicalName(rr.Header().Name)
+		if owner == name {
+			exists = true
+			if t := rr.Header().Rrtype; t == q.Qtype || t == mdns.TypeCNAME {
+				m.Answer = append(m.Answer, rr)
+			}
+		} else if mdns.IsSubDomain(name, owner) && !zone.entNXDOMAIN {
+			exists = true
+		}
+	}
+	m.Answer = append(m.Answer, zone.inject...)
+	if len(m.Answer) == 0 {
+		if !exists && name != zone.name {
+			m.Rcode = mdns.RcodeNameError
+		}
+		soa := &mdns.SOA{
+			Hdr:    mdns.RR_Header{Name: zone.name, Rrtype: mdns.TypeSOA, Class: mdns.ClassINET, Ttl: 300},
+			Ns:     "ns." + zone.name,
+			Mbox:   "admin." + zone.name,
+			Minttl: 300,
+		}
+		m.Ns = append(m.Ns, soa)
+	}
+	_ = w.WriteMsg(m)
+}
+
+// recursiveFixture 根、TLD 与权威服务器分别监听 127.0.0.1 / .2 / .3 的同一端口
+type recursiveFixture struct {
+	r               *recursor
+	root, tld, leaf *authServer
+}
+
+func newRecursiveFixture(t *testing.T) *recursiveFixture {
+	t.Helper()
+	rr := func(s string) mdns.RR { return mustRR(t, s) }
+
+	f := &recursiveFixture{
+		root: &authServer{zones: []*authZone{{
+			name: ".",
+			delegations: map[string][]mdns.RR{
+				"test.": {rr("test. 3600 IN NS ns1.test."), rr("ns1.test. 3600 IN A 127.0.0.2")},
+			},
+		}}},
+		tld: &authServer{zones: []*authZone{{
+			name: "test.",
+			delegations: map[string][]mdns.RR{
+				// 带 glue 的委派
+				"example.test.": {rr("example.test. 3600 IN NS ns.example.test."), rr("ns.example.test. 3600 IN A 127.0.0.3")},
+				"other.test.":   {rr("other.test. 3600 IN NS ns.example.test."), rr("ns.example.test. 3600 IN A 127.0.0.3")},
+				"broken.test.":  {rr("broken.test. 3600 IN NS ns.example.test."), rr("ns.example.test. 3600 IN A 127.0.0.3")},
+				"evil.test.":    {rr("evil.test. 3600 IN NS ns.example.test."), rr("ns.example.test. 3600 IN A 127.0.0.3")},
+				// 区外 NS，没有 glue，需要先解析 ns.example.test.
+				"noglue.test.": {rr("noglue.test. 3600 IN NS ns.example.test.")},
+			},
+		}}},
+		leaf: &authServer{zones: []*authZone{
+			{name: "example.test.", records: []mdns.RR{
+				rr("ns.example.test. 3600 IN A 127.0.0.3"),
+				rr("www.example.test. 300 IN A 192.0.2.1"),
+				rr("mail.example.test. 300 IN A 192.0.2.2"),
+				rr("alias.example.test. 300 IN CNAME www.other.test."),
+			}},
+			{name: "other.test.", records: []mdns.RR{
+				rr("www.other.test. 300 IN A 192.0.2.10"),
+			}},
+			{name: "noglue.test.", records: []mdns.RR{
+				rr("www.noglue.test. 300 IN A 192.0.2.20"),
+			}},
+			{name: "broken.test.", entNXDOMAIN: true, records: []mdns.RR{
+				rr("a.b.broken.test. 300 IN A 192.0.2.30"),
+			}},
+			// 在应答中夹带其他区与链外的记录
+			{name: "evil.test.", records: []mdns.RR{
+				rr("www.evil.test. 300 IN A 192.0.2.40"),
+				rr("hijack.evil.test. 300 IN CNAME www.other.test."),
+			}, inject: []mdns.RR{
+				rr("www.example.test. 300 IN A 203.0.113.66"),
+				rr("www.other.test. 300 IN A 203.0.113.66"),
+				rr("other.evil.test. 300 IN A 203.0.113.66"),
+			}},
+		}},
+	}
+
+	port := f.listen(t, "127.0.0.1:0", f.root)
+	f.listen(t, net.JoinHostPort("127.0.0.2", port), f.tld)
+	f.listen(t, net.JoinHostPort("127.0.0.3", port), f.leaf)
+
+	f.r = &recursor{
+		hints:    []string{net.JoinHostPort("127.0.0.1", port)},
+		port:     port,
+		minimise: true,
+		ns:       make(map[string]*nsEntry),
+	}
+	return f
+}
+
+// listen 在 addr 上启动 UDP 服务器，返回实际监听的端口
+func (f *recursiveFixture) listen(t *testing.T, addr string, a *authServer) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Skipf("无法监听 %s: %v", addr, err)
+	}
+	srv := &mdns.Server{PacketConn: pc, Handler: mdns.HandlerFunc(a.serve)}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	_, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	return port
+}
+
+func (f *recursiveFixture) resolve(t *testing.T, name string, qtype uint16) *mdns.Msg {
+	t.Helper()
+	req := new(mdns.Msg)
+	req.SetQuestion(name, qtype)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := f.r.exchange(ctx, req)
+	if err != nil {
+		t.Fatalf("解析 %s 失败: %v", name, err)
+	}
+	return resp
+}
+
+// answerAddrs 返回应答中的 A 记录地址
+func answerAddrs(m *mdns.Msg) []string {
+	var out []string
+	for _, rr := range m.Answer {
+		if a, ok := rr.(*mdns.A); ok {
+			out = append(out, a.A.String())
+		}
+	}
+	return out
+}
+
+func TestRecursiveReferralWithGlue(t *testing.T) {
+	f := newRecursiveFixture(t)
+	resp := f.resolve(t, "www.example.test.", mdns.TypeA)
+	if got := answerAddrs(resp); len(got) != 1 || got[0] != "192.0.2.1" {
+		t.Fatalf("answer = %v, want [192.0.2.1]", got)
+	}
+	if zone, servers := f.r.closestZone("www.example.test.", mdns.TypeA); zone != "example.test." || len(servers) != 1 || !strings.HasPrefix(servers[0], "127.0.0.3:") {
+		t.Errorf("closestZone = %s %v, want example.test. [127.0.0.3:*]", zone, servers)
+	}
+}
+
+func TestRecursiveReferralWithoutGlue(t *testing.T) {
+	f := newRecursiveFixture(t)
+	resp := f.resolve(t, "www.noglue.test.", mdns.TypeA)
+	if got := answerAddrs(resp); len(got) != 1 || got[0] != "192.0.2.20" {
+		t.Fatalf("answer = %v, want [192.0.2.20]", got)
+	}
+	// resolveNS 解析 NS 名称时已缓存其所在区
+	if zone, _ := f.r.closestZone("ns.example.test.", mdns.TypeA); zone != "example.test." {
+		t.Errorf("closestZone(ns.example.test.) = %s, want example.test.", zone)
+	}
+}
+
+func TestRecursiveMinimisationFallback(t *testing.T) {
+	f := newRecursiveFixture(t)
+	// b.broken.test. 是空非终端，权威服务器错误地返回 NXDOMAIN，应改为查询完整名称
+	resp := f.resolve(t, "a.b.broken.test.", mdns.TypeA)
+	if resp.Rcode != mdns.RcodeSuccess {
+		t.Fatalf("rcode = %s, want NOERROR", mdns.RcodeToString[resp.Rcode])
+	}
+	if got := answerAddrs(resp); len(got) != 1 || got[0] != "192.0.2.30" {
+		t.Fatalf("answer = %v, want [192.0.2.30]", got)
+	}
+}
+
+func TestRecursiveCrossZoneCNAME(t *testing.T) {
+	f := newRecursiveFixture(t)
+	resp := f.resolve(t, "alias.example.test.", mdns.TypeA)
+	if len(resp.Answer) != 2 {
+		t.Fatalf("answer = %v, want CNAME + A", resp.Answer)
+	}
+	if c, ok := resp.Answer[0].(*mdns.CNAME); !ok || c.Target != "www.other.test." {
+		t.Errorf("answer[0] = %v, want CNAME www.other.test.", resp.Answer[0])
+	}
+	if got := answerAddrs(resp); len(got) != 1 || got[0] != "192.0.2.10" {
+		t.Errorf("answer = %v, want [192.0.2.10]", got)
+	}
+}
+
+func TestRecursiveNSCacheReuse(t *testing.T) {
+	f := newRecursiveFixture(t)
+	f.resolve(t, "www.example.test.", mdns.TypeA)
+	rootQueries, tldQueries := f.root.queries.Load(), f.tld.queries.Load()
+
+	// 同一区的其他名称直接从缓存的委派开始，不再询问根与 TLD
+	resp := f.resolve(t, "mail.example.test.", mdns.TypeA)
+	if got := answerAddrs(resp); len(got) != 1 || got[0] != "192.0.2.2" {
+		t.Fatalf("answer = %v, want [192.0.2.2]", got)
+	}
+	if n := f.root.queries.Load() - rootQueries; n != 0 {
+		t.Errorf("root received %d more queries, want 0", n)
+	}
+	if n := f.tld.queries.Load() - tldQueries; n != 0 {
+		t.Errorf("tld received %d more queries, want 0", n)
+	}
+
+	// 过期的委派不再使用
+	f.r.mu.Lock()
+	f.r.ns["example.test."].expireAt = time.Now().Add(-time.Second)
+	f.r.mu.Unlock()
+	if zone, _ := f.r.closestZone("www.example.test.", mdns.TypeA); zone != "test." {
+		t.Errorf("closestZone after expiry = %s, want test.", zone)
+	}
+}
+
+func TestRecursiveOutOfBailiwickAnswer(t *testing.T) {
+	f := newRecursiveFixture(t)
+
+	// 区外与链外的记录被丢弃
+	resp := f.resolve(t, "www.evil.test.", mdns.TypeA)
+	if len(resp.Answer) != 1 {
+		t.Fatalf("answer = %v, want only www.evil.test.", resp.Answer)
+	}
+	if got := answerAddrs(resp); got[0] != "192.0.2.40" {
+		t.Errorf("answer = %v, want [192.0.2.40]", got)
+	}
+
+	// 指向其他区的 CNAME 由该区的权威服务器重新解析，不采用夹带的地址
+	resp = f.resolve(t, "hijack.evil.test.", mdns.TypeA)
+	if got := answerAddrs(resp); len(got) != 1 || got[0] != "192.0.2.10" {
+		t.Fatalf("answer = %v, want [192.0.2.10]", resp.Answer)
+	}
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*mdns.A); ok && a.A.String() == "203.0.113.66" {
+			t.Errorf("injected record accepted: %v", rr)
+		}
+	}
+}
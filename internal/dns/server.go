@@ -6,18 +6,22 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/netip"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	mdns "github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
 )
 
 // CacheEntry DNS缓存条目
 type CacheEntry struct {
 	Response *mdns.Msg
+	StoredAt time.Time // 写入时间，命中时各记录的 TTL 按已缓存的时间递减
 	ExpireAt time.Time
 	Hits     int64 // 命中次数
 }
@@ -29,23 +33,37 @@ type Server struct {
 	// 最近查询日志（环形缓冲）
 	logs []QueryLog
 
-	// 预编译的规则列表（已标准化为小写、带或不带前导点的一致形式）
+	// 预编译的规则列表（已标准化为 ParseDomainRule 的标准写法）
 	compiledChina []string
 	compiledGfw   []string
 	compiledAds   []string
 
-	// 上游健康状态（简单熔断）
+	// 规则匹配器（ReloadRules 时构建，按分类 china/gfw/ads 索引）
+	ruleMatchers map[string]*domainMatcher
+
+	// SyncManager 同步得到的规则，重载时与配置、订阅规则合并
+	syncedRules map[string][]string
+
+	// DoH 客户端（共享 HTTP/2 连接池）
+	doh *dohClient
+	// DoT 连接池（按上游复用流水线连接）
+	dot *dotPool
+	// DoQ 连接池（按上游复用 QUIC 连接）
+	doq *doqPool
+
+	// 进行中的上游请求，相同 (qname, qtype, route) 的并发查询合并为一次
+	inflight singleflight.Group
+
+	// 上游健康状态（熔断与延迟统计）
 	healthMu       sync.Mutex
 	upstreamHealth map[string]*healthState
+	// round-robin 策略的轮询计数（按路由）
+	roundRobin sync.Map
 
-	// DNS缓存
-	cacheMu    sync.RWMutex
-	cache      map[string]*CacheEntry
-	cacheStats struct {
-		hits   int64
-		misses int64
-		size   int64
-	}
+	// DNS缓存（分片 LRU，容量由 max_cache_entries 限定）
+	cache *dnsCache
+	// 正在预取的缓存键
+	prefetching sync.Map
 
 	// 延迟统计相关字段
 	latencyStats struct {
@@ -66,13 +84,46 @@ type Server struct {
 
 	// 代理管理器
 	proxyManager *ProxyManager
+
+	// 中国 IP 段（用于校验未命中规则域名的 china 上游应答）
+	chinaIP *ChinaIPManager
+
+	// 本地权威记录（local_records / hosts_file / API）
+	localRecords *LocalRecordManager
+
+	// 客户端组策略
+	clientPolicies *ClientPolicyManager
+
+	// 未命中规则域名的分类学习（未启用时为 nil）
+	learner *RuleLearner
+
+	// 客户端查询限速与响应限速（未启用时为 nil）
+	rateLimit *rateLimiter
+
+	// 条件转发区域与私有反向解析
+	zones *zoneRouter
+
+	// 按路由的 ECS 策略（未配置的路由原样转发）
+	ecs map[string]*ecsPolicy
+
+	// DNSSEC 校验器（未启用时为 nil）
+	dnssec *dnssecValidator
+
+	// 内置递归解析器（未启用时为 nil）
+	recursor *recursor
+
+	// 污染应答过滤器（未启用时为 nil）
+	poison *poisonFilter
 }
 
 func NewServer(cfg *Config) (*Server, error) {
 	srv := &Server{
 		cfg:            cfg,
 		upstreamHealth: make(map[string]*healthState),
-		cache:          make(map[string]*CacheEntry),
+		doh:            newDoHClient(),
+		dot:            newDoTPool(),
+		doq:            newDoQPool(),
+		cache:          newDNSCache(cfg.GetMaxCacheEntries()),
 	}
 
 	// 初始化延迟统计
@@ -151,6 +202,40 @@ func NewServer(cfg *Config) (*Server, error) {
 		}
 	}
 
+	// 初始化本地记录
+	srv.localRecords = NewLocalRecordManager(cfg, srv.persistence)
+	go srv.localRecords.Start()
+
+	// 初始化客户端组策略
+	srv.clientPolicies = NewClientPolicyManager(cfg, srv.persistence)
+
+	// 初始化限速
+	srv.rateLimit = newRateLimiter(cfg)
+
+	// 初始化条件转发区域
+	srv.zones = newZoneRouter(cfg)
+
+	// 初始化 ECS 策略
+	srv.ecs = newECSPolicies(cfg)
+
+	// 初始化 DNSSEC 校验
+	srv.dnssec = newDNSSECValidator(srv, cfg)
+
+	// 初始化内置递归解析
+	srv.recursor = newRecursor(cfg)
+
+	// 初始化污染应答过滤
+	srv.poison = newPoisonFilter(cfg)
+
+	// 初始化中国 IP 校验
+	if cfg.IsChinaIPVerifyEnabled() {
+		srv.chinaIP = NewChinaIPManager(cfg)
+		go srv.chinaIP.Start()
+	}
+
+	// 初始化规则学习，学习规则变化时重载规则
+	srv.learner = NewRuleLearner(cfg, srv.persistence, func() { _ = srv.ReloadRules() })
+
 	_ = srv.ReloadRules()
 
 	// 启动缓存清理协程
@@ -165,9 +250,7 @@ func NewServer(cfg *Config) (*Server, error) {
 func (s *Server) loadPersistedData() {
 	// 加载缓存数据
 	if cache, err := s.persistence.LoadCache(); err == nil {
-		s.cacheMu.Lock()
-		s.cache = cache
-		s.cacheMu.Unlock()
+		s.cache.load(cache)
 		fmt.Printf("从持久化存储加载缓存: %d 个条目\n", len(cache))
 	}
 
@@ -208,7 +291,7 @@ func (s *Server) SaveData() error {
 	}
 
 	// 保存缓存数据
-	if err := s.persistence.SaveCache(s.cache); err != nil {
+	if err := s.persistence.SaveCache(s.cache.snapshot()); err != nil {
 		fmt.Printf("保存缓存数据失败: %v\n", err)
 	}
 
@@ -246,6 +329,13 @@ func (s *Server) ReloadRules() error {
 	gfwDomains := s.cfg.GetGFWDomains()
 	adsDomains := s.cfg.GetAdsDomains()
 
+	// 合并 SyncManager 同步的规则
+	if len(s.syncedRules) > 0 {
+		chinaDomains = mergeAndDeduplicate(chinaDomains, s.syncedRules["china"])
+		gfwDomains = mergeAndDeduplicate(gfwDomains, s.syncedRules["gfw"])
+		adsDomains = mergeAndDeduplicate(adsDomains, s.syncedRules["ads"])
+	}
+
 	// 如果启用了订阅，合并订阅规则
 	if s.subscriptionManager != nil {
 		subscriptionChina := s.subscriptionManager.GetRules("china")
@@ -263,9 +353,20 @@ func (s *Server) ReloadRules() error {
 			len(adsDomains), len(s.cfg.GetAdsDomains()), len(subscriptionAds))
 	}
 
-	s.compiledChina = normalizeSuffixes(chinaDomains)
-	s.compiledGfw = normalizeSuffixes(gfwDomains)
-	s.compiledAds = normalizeSuffixes(adsDomains)
+	// 合并自动学习的规则
+	chinaDomains = mergeAndDeduplicate(chinaDomains, s.learner.Rules("china"))
+	gfwDomains = mergeAndDeduplicate(gfwDomains, s.learner.Rules("gfw"))
+
+	var chinaMatcher, gfwMatcher, adsMatcher *domainMatcher
+	s.compiledChina, chinaMatcher = compileRules(chinaDomains)
+	s.compiledGfw, gfwMatcher = compileRules(gfwDomains)
+	s.compiledAds, adsMatcher = compileRules(adsDomains)
+
+	s.ruleMatchers = map[string]*domainMatcher{
+		"china": chinaMatcher,
+		"gfw":   gfwMatcher,
+		"ads":   adsMatcher,
+	}
 
 	return nil
 }
@@ -273,8 +374,11 @@ func (s *Server) ReloadRules() error {
 // SetRules 原子更新规则（由 SyncManager 或 API 调用）
 func (s *Server) SetRules(china, gfw, ads []string) {
 	s.mu.Lock()
-	// 注意：这里需要更新配置，但新配置结构不支持直接修改
-	// 可以考虑添加配置更新方法或使用配置管理器
+	s.syncedRules = map[string][]string{
+		"china": china,
+		"gfw":   gfw,
+		"ads":   ads,
+	}
 	s.mu.Unlock()
 	_ = s.ReloadRules()
 }
@@ -294,210 +398,385 @@ func (s *Server) ServeTCP(ln net.Listener) {
 }
 
 func (s *Server) handle(w mdns.ResponseWriter, r *mdns.Msg) {
+	// 限速：超限的查询已被丢弃、截断或拒绝；通过的响应写出前再按 (客户端, 查询名) 限速
+	w, ok := s.rateLimit.admit(w, r)
+	if !ok {
+		return
+	}
 	if len(r.Question) == 0 {
 		_ = w.WriteMsg(new(mdns.Msg))
 		return
 	}
 	q := r.Question[0]
 	name := strings.TrimSuffix(strings.ToLower(q.Name), ".")
-	qtype := mdns.TypeToString[q.Qtype]
 
-	// 首先尝试从缓存获取
-	if cachedResp, hit := s.getFromCache(name, qtype); hit {
-		s.addLog(name, "cache", 0) // 缓存命中，延迟为0
-		queryCounter.WithLabelValues("cache").Inc()
-		_ = w.WriteMsg(cachedResp)
+	// 按客户端地址选择策略（规则分类、上游组、拦截模式）
+	policy := s.clientPolicies.Lookup(w.RemoteAddr())
+
+	// 本地权威记录优先于缓存与上游
+	if answers, ok := s.localRecords.Lookup(name, q.Qtype, q.Qclass); ok {
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.RecursionAvailable = true
+		m.Answer = answers
+		s.addLog(newQueryLog(w, r, m, policy, "local", 0))
+		queryCounter.WithLabelValues("local").Inc()
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	// 条件转发区域与私有反向解析先于分流与广告拦截，且不会泄露到 china / intl 上游
+	if zone := s.zones.match(name); zone != nil {
+		s.resolveZone(w, r, policy, "zone:"+zone.Zone, zone.Upstreams)
+		return
+	}
+	if s.zones.isPrivatePTR(name) {
+		if ups := s.cfg.PrivatePTR.Upstreams; len(ups) > 0 {
+			s.resolveZone(w, r, policy, "private-ptr", ups)
+			return
+		}
+		m := privatePTRResponse(r)
+		s.addLog(newQueryLog(w, r, m, policy, "private-ptr", 0))
+		queryCounter.WithLabelValues("private-ptr").Inc()
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	isAds, blockMode, adguardUps := s.adsRoute(name, policy)
+	if blockMode != "" {
+		m := s.blockResponse(r, blockMode)
+		entry := newQueryLog(w, r, m, policy, "blocked", 0)
+		entry.Blocked = true
+		s.addLog(entry)
+		queryCounter.WithLabelValues("blocked").Inc()
+		blockedCounter.WithLabelValues(blockMode).Inc()
+		_ = w.WriteMsg(m)
 		return
 	}
 
-	// 缓存未命中，记录统计
-	s.cacheMu.Lock()
-	s.cacheStats.misses++
-	s.cacheMu.Unlock()
+	// 缓存命中直接应答；未命中时转发上游，有过期缓存时按 serve-stale 处理
+	client, _ := addrIP(w.RemoteAddr())
+	resolve := func() resolution { return s.resolveUpstream(r, client, policy, name, isAds, adguardUps) }
+	routes := []string{"china", "intl"}
+	if isAds {
+		routes = []string{"adguard"}
+	}
+	key := s.generateCacheKey(r, policy, client, routes...)
+	served, stale := s.serveFromCache(w, r, policy, key, resolve)
+	if served {
+		return
+	}
+	s.serveResolved(w, r, policy, key, stale, resolve)
+}
+
+// resolution 一次上游解析的结果
+type resolution struct {
+	resp     *mdns.Msg
+	route    string
+	upstream string
+	latency  time.Duration
+	err      error
+}
 
-	// 分流：广告 -> adguard；gfw -> intl；china -> china；其他：先 china 失败再 intl
+// resolveUpstream 按规则分流并转发到上游：广告 -> adguard；gfw -> intl；china -> china；其他：先 china 失败再 intl
+func (s *Server) resolveUpstream(r *mdns.Msg, client netip.Addr, policy *clientPolicy, name string, isAds bool, adguardUps []string) resolution {
 	var upstreams []string
 	decision := ""
-	if s.match(name, s.compiledAds) && len(s.cfg.GetAdguardUpstreams()) > 0 {
-		upstreams = s.cfg.GetAdguardUpstreams()
+	outcome := "" // 回退解析中 china 的结果，仅未命中规则的域名使用
+	chinaUps := s.cfg.GetUpstreamGroup(policy.upstreamGroup(UpstreamGroupChina))
+	intlUps := s.cfg.GetUpstreamGroup(policy.upstreamGroup(UpstreamGroupIntl))
+	if isAds {
+		upstreams = adguardUps
 		decision = "adguard"
-	} else if s.match(name, s.compiledGfw) {
-		upstreams = s.cfg.GetIntlUpstreams()
-		decision = "intl"
-	} else if s.match(name, s.compiledChina) {
-		upstreams = s.cfg.GetChinaUpstreams()
-		decision = "china"
+	} else if policy.useRuleSet("gfw") && s.match(name, "gfw") {
+		upstreams, decision = s.categoryRoute("gfw", intlUps, "intl")
+	} else if policy.useRuleSet("china") && s.match(name, "china") {
+		upstreams, decision = s.categoryRoute("china", chinaUps, "china")
+	} else if s.cfg.IsRecursiveCategory("others") {
+		upstreams, decision = []string{RecursiveUpstream}, RecursiveUpstream
 	} else {
 		// fallback：china -> intl
 		startTime := time.Now()
-		if resp, err := s.forward(context.Background(), r, s.cfg.GetChinaUpstreams(), "china"); err == nil && hasAnswer(resp) {
-			// 计算延迟并更新统计
-			latency := time.Since(startTime)
-			s.updateLatencyStats("china", latency)
-
-			s.addLog(name, "china", latency)
-			queryCounter.WithLabelValues("china").Inc()
-			// 缓存响应
-			s.setCache(name, qtype, resp)
-			_ = w.WriteMsg(resp)
-			return
-		}
-		upstreams = s.cfg.GetIntlUpstreams()
 		decision = "intl"
+		resp, upstream, err := s.forwardRoute(context.Background(), r, client, policy, chinaUps, "china")
+		if err == nil && hasAnswer(resp) {
+			route := "china"
+			accepted := true
+			// 启用中国 IP 校验时，应答 IP 不在中国 IP 段内视为污染或 CDN 调度错误，改走 intl
+			if s.chinaIP != nil && s.chinaIP.Loaded() {
+				accepted = s.chinaIP.IsChinaAnswer(resp)
+				route = "china-verified"
+				if !accepted {
+					decision = "intl-fallback"
+				}
+			}
+			if accepted {
+				s.learner.Observe(name, OutcomeChinaOK, false)
+				return resolution{resp: resp, route: route, upstream: upstream, latency: time.Since(startTime)}
+			}
+		}
+		outcome = OutcomeChinaFailed
+		if decision == "intl-fallback" {
+			// 应答 IP 不在中国单独计数，不作为 gfw 依据
+			outcome = OutcomeChinaForeignIP
+		}
+		var pe *poisonedError
+		if errors.As(err, &pe) {
+			s.recordGFWCandidate(name, pe.ip)
+			decision = "intl-fallback"
+			outcome = OutcomeChinaPoisoned
+		}
+		upstreams = intlUps
 	}
 
 	// 记录开始时间用于计算延迟
 	startTime := time.Now()
+	resp, upstream, err := s.forwardRoute(context.Background(), r, client, policy, upstreams, decision)
+	if outcome != "" {
+		// 未命中规则的域名：记录 china 的结果与 intl 是否成功，供规则学习
+		s.learner.Observe(name, outcome, err == nil && hasAnswer(resp))
+	}
+	var pe *poisonedError
+	if decision == "china" && errors.As(err, &pe) {
+		// china 路由被污染时改走 intl
+		s.recordGFWCandidate(name, pe.ip)
+		decision = "intl-fallback"
+		resp, upstream, err = s.forwardRoute(context.Background(), r, client, policy, intlUps, decision)
+	}
+	return resolution{resp: resp, route: decision, upstream: upstream, latency: time.Since(startTime), err: err}
+}
 
-	resp, err := s.forward(context.Background(), r, upstreams, decision)
-	if err != nil {
-		s.writeServFail(w, r)
+// categoryRoute 分类配置为走内置递归解析时使用 recursive 上游，否则使用默认上游组
+func (s *Server) categoryRoute(category string, ups []string, route string) ([]string, string) {
+	if s.cfg.IsRecursiveCategory(category) {
+		return []string{RecursiveUpstream}, RecursiveUpstream
+	}
+	return ups, route
+}
+
+// resolveZone 将查询转发到条件转发区域的上游，route 作为日志与延迟统计的路由名
+func (s *Server) resolveZone(w mdns.ResponseWriter, r *mdns.Msg, policy *clientPolicy, route string, upstreams []string) {
+	client, _ := addrIP(w.RemoteAddr())
+	resolve := func() resolution {
+		startTime := time.Now()
+		resp, upstream, err := s.forwardRoute(context.Background(), r, client, policy, upstreams, route)
+		return resolution{resp: resp, route: route, upstream: upstream, latency: time.Since(startTime), err: err}
+	}
+	key := s.generateCacheKey(r, policy, client, route)
+	served, stale := s.serveFromCache(w, r, policy, key, resolve)
+	if served {
 		return
 	}
+	s.serveResolved(w, r, policy, key, stale, resolve)
+}
 
-	// 计算延迟并更新统计
-	latency := time.Since(startTime)
-	s.updateLatencyStats(decision, latency)
+// serveResolved 执行上游解析并写出应答。存在过期缓存时 (RFC 8767)，上游失败或超过
+// stale_answer_timeout 仍未应答则先返回过期应答，解析在后台继续完成并刷新缓存
+func (s *Server) serveResolved(w mdns.ResponseWriter, r *mdns.Msg, policy *clientPolicy, key string, stale *mdns.Msg, resolve func() resolution) {
+	if stale == nil {
+		res := resolve()
+		s.recordResolution(key, res)
+		s.writeResolution(w, r, policy, res)
+		return
+	}
 
-	s.addLog(name, decision, latency)
-	queryCounter.WithLabelValues(decision).Inc()
+	done := make(chan resolution, 1)
+	go func() {
+		res := resolve()
+		s.recordResolution(key, res)
+		done <- res
+	}()
+	timer := time.NewTimer(s.cfg.GetStaleAnswerTimeout())
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err == nil && res.resp.Rcode != mdns.RcodeServerFailure {
+			s.writeResolution(w, r, policy, res)
+			return
+		}
+	case <-timer.C:
+	}
+	s.writeStale(w, r, policy, stale)
+}
 
-	// 缓存响应
-	s.setCache(name, qtype, resp)
+// recordResolution 解析成功时更新延迟统计与计数，并写入缓存
+func (s *Server) recordResolution(key string, res resolution) {
+	if res.err != nil {
+		return
+	}
+	s.updateLatencyStats(res.route, res.latency)
+	queryCounter.WithLabelValues(res.route).Inc()
+	s.setCache(key, res.resp)
+}
 
-	_ = w.WriteMsg(resp)
+// writeResolution 写出上游应答，失败时写出 SERVFAIL，并记录查询日志
+func (s *Server) writeResolution(w mdns.ResponseWriter, r *mdns.Msg, policy *clientPolicy, res resolution) {
+	if res.err != nil {
+		fail := s.writeServFail(w, r)
+		s.addLog(newQueryLog(w, r, fail, policy, res.route, res.latency))
+		return
+	}
+	entry := newQueryLog(w, r, res.resp, policy, res.route, res.latency)
+	entry.Upstream = res.upstream
+	s.addLog(entry)
+	_ = w.WriteMsg(res.resp)
 }
 
 func hasAnswer(m *mdns.Msg) bool { return m != nil && (len(m.Answer) > 0 || len(m.Ns) > 0) }
 
-func (s *Server) writeServFail(w mdns.ResponseWriter, req *mdns.Msg) {
+func (s *Server) writeServFail(w mdns.ResponseWriter, req *mdns.Msg) *mdns.Msg {
 	m := new(mdns.Msg)
 	m.SetRcode(req, mdns.RcodeServerFailure)
 	_ = w.WriteMsg(m)
+	return m
 }
 
-func (s *Server) match(name string, suffixes []string) bool {
+// match 判断域名是否命中指定分类的规则，name 需为小写且不带末尾的点
+func (s *Server) match(name, category string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, sfx := range suffixes {
-		sfx = strings.ToLower(strings.TrimSpace(sfx))
-		if sfx == "" {
-			continue
-		}
-		if strings.HasSuffix(name, strings.TrimPrefix(sfx, ".")) {
-			return true
+	return s.ruleMatchers[category].Match(name)
+}
+
+// forwardResult 合并请求共享的上游结果
+type forwardResult struct {
+	resp     *mdns.Msg
+	upstream string
+}
+
+// forward 依次尝试上游，返回应答及实际应答的上游地址。
+// 与缓存键相同维度（名称、类型、类别、DO / CD 位、发往上游的 ECS 子网、客户端策略）且路由与上游组都相同的
+// 并发查询只产生一次上游请求，等待者各自获得带自身消息 ID 的应答副本
+func (s *Server) forward(ctx context.Context, req *mdns.Msg, policy *clientPolicy, ups []string, target string) (*mdns.Msg, string, error) {
+	key := queryKey(req, policy) + "|" + target + "|" + strings.Join(ups, ",")
+
+	leader := false
+	v, err, shared := s.inflight.Do(key, func() (interface{}, error) {
+		leader = true
+		resp, upstream, err := s.forwardUpstreams(ctx, req, ups, target)
+		if err != nil {
+			return nil, err
 		}
+		return forwardResult{resp: resp, upstream: upstream}, nil
+	})
+	if !leader {
+		upstreamCoalesced.WithLabelValues(target).Inc()
 	}
-	return false
+	if err != nil {
+		return nil, "", err
+	}
+
+	res := v.(forwardResult)
+	resp := res.resp
+	if shared {
+		// 应答被多个请求共享，复制后再改写消息 ID 与问题
+		resp = resp.Copy()
+		restampResponse(resp, req)
+	}
+	resp.Id = req.Id
+	return resp, res.upstream, nil
 }
 
-func (s *Server) forward(ctx context.Context, req *mdns.Msg, ups []string, target string) (*mdns.Msg, error) {
-	var lastErr error
+// forwardUpstreams 按上游组配置的策略选择上游，跳过处于熔断状态的上游
+func (s *Server) forwardUpstreams(ctx context.Context, req *mdns.Msg, ups []string, target string) (*mdns.Msg, string, error) {
+	group := upstreamGroupOf(target)
+	strategy := s.cfg.GetUpstreamStrategy(group)
+	timeout := s.cfg.GetUpstreamTimeout(group)
+
+	cands := make([]upstream, 0, len(ups))
 	for _, addr := range ups {
-		netw, endpoint := upstreamDialParams(addr)
-		if !s.isUpstreamAvailable(netw, endpoint) {
+		u := parseUpstream(addr)
+		if !s.isUpstreamAvailable(u) {
 			upstreamSkippedUnhealthy.WithLabelValues(target).Inc()
 			continue
 		}
-		c := &mdns.Client{Net: netw, Timeout: 3 * time.Second}
-		start := time.Now()
-		// 这里未直接支持 socks5，建议使用 mihomo 暴露本地 DNS 端口，或在系统层做 socks5 透明转发
-		resp, _, err := c.Exchange(req, endpoint)
-		upstreamLatency.WithLabelValues(target).Observe(time.Since(start).Seconds())
-		if err == nil && resp != nil {
-			s.recordSuccess(netw, endpoint)
-			return resp, nil
-		}
-		s.recordFailure(netw, endpoint, target, err)
-		upstreamFailures.WithLabelValues(target).Inc()
-		lastErr = err
+		cands = append(cands, u)
 	}
-	if lastErr == nil {
-		lastErr = errors.New("no upstream")
+	if len(cands) == 0 {
+		return nil, "", errors.New("no upstream")
 	}
-	return nil, lastErr
-}
 
-func netProto(addr string) string {
-	if strings.HasPrefix(addr, "tls://") || strings.HasPrefix(addr, "https://") {
-		return "tcp"
+	switch strategy {
+	case StrategyParallel:
+		return s.forwardParallel(ctx, req, cands, target, timeout)
+	case StrategyFastest:
+		s.sortByLatency(cands)
+	case StrategyRoundRobin:
+		cands = s.rotate(target, cands)
 	}
-	return "udp"
-}
 
-// upstreamDialParams 解析上游地址，支持 tls:// 和 https:// 前缀的 TCP 连接（仅透传到 miekg/dns，不实现 DoH）。
-func upstreamDialParams(address string) (network, endpoint string) {
-	if strings.HasPrefix(address, "tls://") || strings.HasPrefix(address, "https://") {
-		// miekg/dns 使用 "tcp" 网络，endpoint 保留主机:端口
-		a := strings.TrimPrefix(strings.TrimPrefix(address, "tls://"), "https://")
-		return "tcp", a
+	var lastErr error
+	for _, u := range cands {
+		resp, err := s.attempt(ctx, req, u, target, timeout)
+		if err == nil {
+			return resp, u.Addr, nil
+		}
+		lastErr = err
 	}
-	return "udp", address
+	return nil, "", lastErr
 }
 
-type healthState struct {
-	failures     int
-	trippedUntil time.Time
+// QueryLog 查询日志
+type QueryLog struct {
+	Time        time.Time `json:"time"`
+	Name        string    `json:"name"`
+	Route       string    `json:"route"`
+	Latency     int64     `json:"latency"` // 延迟，单位毫秒
+	ClientIP    string    `json:"client_ip"`
+	ClientGroup string    `json:"client_group,omitempty"`
+	QueryType   string    `json:"query_type"`
+	Rcode       string    `json:"rcode"`
+	Answers     []string  `json:"answers,omitempty"`  // 应答记录，形如 "A 1.2.3.4"
+	Upstream    string    `json:"upstream,omitempty"` // 实际应答的上游
+	Cached      bool      `json:"cached"`
+	Blocked     bool      `json:"blocked"`
 }
 
-// 简单熔断：连续失败 N 次后在 M 时间内跳过该上游
-const (
-	circuitFailThreshold = 3
-	circuitOpenDuration  = 30 * time.Second
-)
-
-func (s *Server) isUpstreamAvailable(network, endpoint string) bool {
-	s.healthMu.Lock()
-	defer s.healthMu.Unlock()
-	st := s.upstreamHealth[network+"|"+endpoint]
-	if st == nil {
-		return true
+// newQueryLog 由请求与应答构造日志条目
+func newQueryLog(w mdns.ResponseWriter, req, resp *mdns.Msg, policy *clientPolicy, route string, latency time.Duration) QueryLog {
+	q := req.Question[0]
+	entry := QueryLog{
+		Name:      strings.TrimSuffix(strings.ToLower(q.Name), "."),
+		Route:     route,
+		Latency:   latency.Milliseconds(),
+		QueryType: mdns.TypeToString[q.Qtype],
 	}
-	if time.Now().Before(st.trippedUntil) {
-		return false
+	if ip, ok := addrIP(w.RemoteAddr()); ok {
+		entry.ClientIP = ip.String()
 	}
-	return true
-}
-
-func (s *Server) recordFailure(network, endpoint, target string, err error) {
-	s.healthMu.Lock()
-	defer s.healthMu.Unlock()
-	key := network + "|" + endpoint
-	st := s.upstreamHealth[key]
-	if st == nil {
-		st = &healthState{}
-		s.upstreamHealth[key] = st
+	if policy != nil && policy != defaultPolicy {
+		entry.ClientGroup = policy.name
 	}
-	st.failures++
-	if st.failures >= circuitFailThreshold {
-		st.trippedUntil = time.Now().Add(circuitOpenDuration)
-		st.failures = 0
-		upstreamCircuitOpened.WithLabelValues(target).Inc()
+	if resp != nil {
+		entry.Rcode = mdns.RcodeToString[resp.Rcode]
+		entry.Answers = formatAnswers(resp.Answer)
 	}
+	return entry
 }
 
-func (s *Server) recordSuccess(network, endpoint string) {
-	s.healthMu.Lock()
-	defer s.healthMu.Unlock()
-	key := network + "|" + endpoint
-	if st := s.upstreamHealth[key]; st != nil {
-		st.failures = 0
-		st.trippedUntil = time.Time{}
+// formatAnswers 将应答记录格式化为 "类型 数据" 的形式
+func formatAnswers(rrs []mdns.RR) []string {
+	if len(rrs) == 0 {
+		return nil
 	}
+	out := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		data := strings.TrimPrefix(rr.String(), hdr.String())
+		out = append(out, mdns.TypeToString[hdr.Rrtype]+" "+data)
+	}
+	return out
 }
 
-type QueryLog struct {
-	Time    time.Time `json:"time"`
-	Name    string    `json:"name"`
-	Route   string    `json:"route"`
-	Latency int64     `json:"latency"` // 延迟，单位毫秒
-}
-
-func (s *Server) addLog(name, route string, latency time.Duration) {
+func (s *Server) addLog(entry QueryLog) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	const max = 1000
-	s.logs = append(s.logs, QueryLog{Time: time.Now(), Name: name, Route: route, Latency: latency.Milliseconds()})
+	s.logs = append(s.logs, entry)
 	if len(s.logs) > max {
 		s.logs = s.logs[len(s.logs)-max:]
 	}
@@ -514,27 +793,91 @@ func (s *Server) GetLogs(limit int) []QueryLog {
 	return out
 }
 
-// normalizeSuffixes 将规则统一为小写去空白的后缀匹配形式
-func normalizeSuffixes(in []string) []string {
-	out := make([]string, 0, len(in))
-	for _, s := range in {
-		s = strings.ToLower(strings.TrimSpace(s))
-		if s == "" {
-			continue
+// LogFilter 查询日志过滤条件，字符串为空或指针为 nil 表示不过滤
+type LogFilter struct {
+	Client    string // 客户端 IP 或 CIDR
+	Name      string // 域名包含
+	QueryType string
+	Rcode     string
+	Route     string
+	Upstream  string // 上游地址包含
+	Answer    string // 应答数据包含，如某个 IP
+	Cached    *bool
+	Blocked   *bool
+	Since     time.Time
+	Limit     int
+}
+
+// QueryLogs 按条件过滤最近的查询日志，返回时间顺序的最后 Limit 条
+func (s *Server) QueryLogs(f LogFilter) []QueryLog {
+	var clientPrefix netip.Prefix
+	if f.Client != "" {
+		if p, err := parseClientPrefix(f.Client); err == nil {
+			clientPrefix = p
+		}
+	}
+	name := strings.ToLower(f.Name)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []QueryLog
+	for i := len(s.logs) - 1; i >= 0; i-- {
+		l := s.logs[i]
+		if !f.Since.IsZero() && l.Time.Before(f.Since) {
+			break
 		}
-		// 与 normalizeDomain 一致，确保有前导点，便于一致匹配
-		if !strings.HasPrefix(s, ".") {
-			if d, ok := normalizeDomain(s); ok {
-				s = d
-			} else {
+		if f.Client != "" {
+			ip, err := netip.ParseAddr(l.ClientIP)
+			if !clientPrefix.IsValid() || err != nil || !clientPrefix.Contains(ip) {
 				continue
 			}
 		}
-		out = append(out, s)
+		if name != "" && !strings.Contains(l.Name, name) {
+			continue
+		}
+		if f.QueryType != "" && !strings.EqualFold(l.QueryType, f.QueryType) {
+			continue
+		}
+		if f.Rcode != "" && !strings.EqualFold(l.Rcode, f.Rcode) {
+			continue
+		}
+		if f.Route != "" && l.Route != f.Route {
+			continue
+		}
+		if f.Upstream != "" && !strings.Contains(l.Upstream, f.Upstream) {
+			continue
+		}
+		if f.Answer != "" && !containsAnswer(l.Answers, f.Answer) {
+			continue
+		}
+		if f.Cached != nil && l.Cached != *f.Cached {
+			continue
+		}
+		if f.Blocked != nil && l.Blocked != *f.Blocked {
+			continue
+		}
+		out = append(out, l)
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	// 恢复为时间顺序
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
 	}
 	return out
 }
 
+func containsAnswer(answers []string, sub string) bool {
+	for _, a := range answers {
+		if strings.Contains(a, sub) {
+			return true
+		}
+	}
+	return false
+}
+
 var (
 	queryCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -572,10 +915,24 @@ var (
 		},
 		[]string{"target"},
 	)
+	upstreamCoalesced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_upstream_coalesced_total",
+			Help: "Queries answered by sharing an identical in-flight upstream request",
+		},
+		[]string{"target"},
+	)
+	blockedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "boomdns_blocked_total",
+			Help: "Queries blocked locally by blocking mode",
+		},
+		[]string{"mode"},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(queryCounter, upstreamLatency, upstreamFailures, upstreamCircuitOpened, upstreamSkippedUnhealthy)
+	prometheus.MustRegister(queryCounter, upstreamLatency, upstreamFailures, upstreamCircuitOpened, upstreamSkippedUnhealthy, upstreamCoalesced, blockedCounter)
 }
 
 // cacheCleaner 定期清理过期缓存
@@ -597,125 +954,79 @@ func (s *Server) cacheCleaner() {
 
 // cleanExpiredCache 清理过期的缓存条目
 func (s *Server) cleanExpiredCache() {
-	s.cacheMu.Lock()
-	defer s.cacheMu.Unlock()
-
-	now := time.Now()
-	expiredKeys := make([]string, 0)
-
-	for key, entry := range s.cache {
-		if now.After(entry.ExpireAt) {
-			expiredKeys = append(expiredKeys, key)
-		}
-	}
-
-	for _, key := range expiredKeys {
-		delete(s.cache, key)
-	}
-
-	s.cacheStats.size = int64(len(s.cache))
+	// serve-stale 窗口内的过期条目保留
+	s.cache.removeExpired(time.Now().Add(-s.cfg.GetStaleWindow()))
 }
 
-// generateCacheKey 生成缓存键
-func (s *Server) generateCacheKey(qname, qtype string) string {
-	return qname + ":" + qtype
+// queryKey 合并请求键的查询维度：发往上游的请求维度（含实际转发的 ECS 子网）与客户端策略
+func queryKey(req *mdns.Msg, policy *clientPolicy) string {
+	return requestKey(req) + "|" + policy.key
 }
 
-// getFromCache 从缓存获取DNS响应
-func (s *Server) getFromCache(qname, qtype string) (*mdns.Msg, bool) {
-	s.cacheMu.RLock()
-	defer s.cacheMu.RUnlock()
-
-	key := s.generateCacheKey(qname, qtype)
-	entry, exists := s.cache[key]
-
-	if !exists {
-		return nil, false
-	}
-
-	// 检查是否过期
-	if time.Now().After(entry.ExpireAt) {
-		return nil, false
-	}
-
-	// 检查响应是否有效
-	if entry.Response == nil {
-		return nil, false
+// generateCacheKey 生成缓存键：查询维度（名称、类型、类别、DO / CD 位）、客户端策略（规则分类、
+// 上游组与拦截设置），以及查询可能经过的路由 routes 发往上游的 ECS 子网
+func (s *Server) generateCacheKey(req *mdns.Msg, policy *clientPolicy, client netip.Addr, routes ...string) string {
+	key := questionKey(req) + "|" + policy.key
+	if scope := s.ecsScope(req, client, routes); scope != "" {
+		key += "|" + scope
 	}
-
-	// 增加命中次数
-	entry.Hits++
-	s.cacheStats.hits++
-
-	// 返回缓存的响应副本
-	response := entry.Response.Copy()
-	return response, true
+	return key
 }
 
 // setCache 设置缓存
-func (s *Server) setCache(qname, qtype string, response *mdns.Msg) {
-	s.cacheMu.Lock()
-	defer s.cacheMu.Unlock()
-
-	key := s.generateCacheKey(qname, qtype)
-
-	// 计算TTL（使用响应中的最小TTL）
-	ttl := time.Duration(300) * time.Second // 默认5分钟
-	if len(response.Answer) > 0 {
-		if answer, ok := response.Answer[0].(*mdns.A); ok {
-			ttl = time.Duration(answer.Hdr.Ttl) * time.Second
-		}
+func (s *Server) setCache(key string, response *mdns.Msg) {
+	ttl := s.cacheTTL(response)
+	if ttl <= 0 {
+		return
 	}
-
-	entry := &CacheEntry{
-		Response: response.Copy(),
-		ExpireAt: time.Now().Add(ttl),
-		Hits:     0,
+	resp := response.Copy()
+	if isNegativeResponse(resp) {
+		clampRecordTTLs(resp, 0, uint32(ttl/time.Second))
+	} else {
+		clampRecordTTLs(resp, s.cfg.GetCacheMinTTL(), s.cfg.GetCacheMaxTTL())
 	}
-
-	s.cache[key] = entry
-	s.cacheStats.size = int64(len(s.cache))
+	now := time.Now()
+	s.cache.set(key, &CacheEntry{
+		Response: resp,
+		StoredAt: now,
+		ExpireAt: now.Add(ttl),
+	})
 }
 
 // GetCacheStats 获取缓存统计信息
 func (s *Server) GetCacheStats() map[string]interface{} {
-	s.cacheMu.RLock()
-	defer s.cacheMu.RUnlock()
+	hits, misses := s.cache.hits.Load(), s.cache.misses.Load()
 
 	// 计算缓存命中率
 	var hitRate float64
-	total := s.cacheStats.hits + s.cacheStats.misses
+	total := hits + misses
 	if total > 0 {
-		hitRate = float64(s.cacheStats.hits) / float64(total) * 100
+		hitRate = float64(hits) / float64(total) * 100
 	}
 
+	size := s.cache.size.Load()
 	return map[string]interface{}{
-		"hits":     s.cacheStats.hits,
-		"misses":   s.cacheStats.misses,
+		"hits":     hits,
+		"misses":   misses,
 		"hit_rate": hitRate,
-		"size":     s.cacheStats.size,
-		"entries":  len(s.cache),
+		"size":     size,
+		"entries":  size,
+		"capacity": s.cfg.GetMaxCacheEntries(),
 	}
 }
 
-// GetCacheEntries 获取缓存条目列表
+// GetCacheEntries 获取缓存条目列表，按命中次数降序
 func (s *Server) GetCacheEntries(limit int) []map[string]interface{} {
-	s.cacheMu.RLock()
-	defer s.cacheMu.RUnlock()
-
-	entries := make([]map[string]interface{}, 0, len(s.cache))
+	entries := make([]map[string]interface{}, 0)
 	now := time.Now()
 
-	for key, entry := range s.cache {
-		if len(entries) >= limit {
-			break
-		}
-
+	s.cache.each(func(key string, entry *CacheEntry) bool {
 		// 解析key获取域名和类型
-		parts := strings.Split(key, ":")
-		qname := parts[0]
-		qtype := parts[1]
-
+		parts := strings.SplitN(key, ":", 3)
+		qname, qtype := strings.TrimSuffix(parts[0], "."), ""
+		if len(parts) > 1 {
+			qtype = parts[1]
+		}
 		entries = append(entries, map[string]interface{}{
 			"domain":   qname,
 			"type":     qtype,
@@ -724,23 +1035,21 @@ func (s *Server) GetCacheEntries(limit int) []map[string]interface{} {
 			"ttl_left": entry.ExpireAt.Sub(now).String(),
 			"expired":  now.After(entry.ExpireAt),
 		})
-	}
-
-	// 按命中次数排序
-	// 这里简化处理，实际可以添加排序逻辑
+		return true
+	})
 
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i]["hits"].(int64) > entries[j]["hits"].(int64)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
 	return entries
 }
 
 // ClearCache 清空缓存
 func (s *Server) ClearCache() {
-	s.cacheMu.Lock()
-	defer s.cacheMu.Unlock()
-
-	s.cache = make(map[string]*CacheEntry)
-	s.cacheStats.hits = 0
-	s.cacheStats.misses = 0
-	s.cacheStats.size = 0
+	s.cache.clear()
 }
 
 // GetRules 获取当前规则
@@ -755,6 +1064,88 @@ func (s *Server) GetRules() map[string][]string {
 	}
 }
 
+// GetLearnedRules 列出自动学习的规则，status 为空时返回全部
+func (s *Server) GetLearnedRules(status string) ([]LearnedRule, error) {
+	if s.learner == nil {
+		return nil, fmt.Errorf("规则学习未启用")
+	}
+	return s.learner.List(status), nil
+}
+
+// GetDomainOutcome 获取域名在回退解析中的累计结果
+func (s *Server) GetDomainOutcome(domain string) (*DomainOutcome, error) {
+	if s.learner == nil {
+		return nil, fmt.Errorf("规则学习未启用")
+	}
+	return s.learner.store.GetDomainOutcome(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), "."))
+}
+
+// ConfirmLearnedRule 确认学习规则
+func (s *Server) ConfirmLearnedRule(domain string) (*LearnedRule, error) {
+	if s.learner == nil {
+		return nil, fmt.Errorf("规则学习未启用")
+	}
+	return s.learner.SetStatus(domain, LearnedStatusConfirmed)
+}
+
+// RejectLearnedRule 拒绝学习规则：规则不再生效，该域名也不会再被自动学习
+func (s *Server) RejectLearnedRule(domain string) (*LearnedRule, error) {
+	if s.learner == nil {
+		return nil, fmt.Errorf("规则学习未启用")
+	}
+	return s.learner.SetStatus(domain, LearnedStatusRejected)
+}
+
+// RuleSearchResult 规则搜索结果
+type RuleSearchResult struct {
+	Category string `json:"category"`
+	Rule     string `json:"rule"`
+	RuleType string `json:"rule_type"`
+	Value    string `json:"value"`
+	Matched  bool   `json:"matched"` // 查询词作为域名时是否命中该规则
+}
+
+// SearchRules 搜索规则：返回能匹配查询域名（按规则语义）或文本包含查询词的规则
+func (s *Server) SearchRules(query string) []RuleSearchResult {
+	query = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(query)), ".")
+	if query == "" {
+		return nil
+	}
+
+	var results []RuleSearchResult
+	for category, rules := range s.GetRules() {
+		for _, raw := range rules {
+			rule, err := ParseDomainRule(raw)
+			if err != nil {
+				continue
+			}
+			matched := rule.Match(query)
+			if !matched && !strings.Contains(strings.ToLower(raw), query) {
+				continue
+			}
+			results = append(results, RuleSearchResult{
+				Category: category,
+				Rule:     raw,
+				RuleType: rule.Type,
+				Value:    rule.Value,
+				Matched:  matched,
+			})
+		}
+	}
+
+	// 命中的规则排在前面
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Matched != results[j].Matched {
+			return results[i].Matched
+		}
+		if results[i].Category != results[j].Category {
+			return results[i].Category < results[j].Category
+		}
+		return results[i].Rule < results[j].Rule
+	})
+	return results
+}
+
 // GetSyncStatus 获取同步状态
 func (s *Server) GetSyncStatus() map[string]interface{} {
 	return map[string]interface{}{
@@ -922,6 +1313,16 @@ func (s *Server) GetStorageManager() StorageManager {
 	return s.persistence
 }
 
+// GetLocalRecordManager 获取本地记录管理器（公共方法）
+func (s *Server) GetLocalRecordManager() *LocalRecordManager {
+	return s.localRecords
+}
+
+// GetClientPolicyManager 获取客户端组策略管理器（公共方法）
+func (s *Server) GetClientPolicyManager() *ClientPolicyManager {
+	return s.clientPolicies
+}
+
 // GetProxyManager 获取代理管理器（公共方法）
 func (s *Server) GetProxyManager() *ProxyManager {
 	return s.proxyManager
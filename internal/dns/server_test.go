@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// startTestUpstream 启动由 handler 应答的 UDP 上游，返回地址
+func startTestUpstream(t *testing.T, handler mdns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{PacketConn: pc, Handler: handler}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// countingUpstream 延迟 delay 后应答 ip 并统计收到的查询数
+func countingUpstream(t *testing.T, ip string, delay time.Duration) (string, *atomic.Int64) {
+	t.Helper()
+	var n atomic.Int64
+	addr := startTestUpstream(t, func(w mdns.ResponseWriter, r *mdns.Msg) {
+		n.Add(1)
+		time.Sleep(delay)
+		m := new(mdns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &mdns.A{
+			Hdr: mdns.RR_Header{Name: r.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(ip),
+		})
+		_ = w.WriteMsg(m)
+	})
+	return addr, &n
+}
+
+func TestForwardCoalescing(t *testing.T) {
+	s := newTestServer(&Config{})
+	addr, queries := countingUpstream(t, "192.0.2.1", 100*time.Millisecond)
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(id uint16) {
+			defer wg.Done()
+			req := new(mdns.Msg)
+			req.SetQuestion("www.example.com.", mdns.TypeA)
+			req.Id = id
+			resp, _, err := s.forward(context.Background(), req, defaultPolicy, []string{addr}, "intl")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// 每个等待者获得带自身消息 ID 的应答
+			if resp.Id != id || len(resp.Answer) != 1 {
+				t.Errorf("resp id = %d answer = %v, want id %d", resp.Id, resp.Answer, id)
+			}
+		}(uint16(1000 + i))
+	}
+	wg.Wait()
+	if got := queries.Load(); got != 1 {
+		t.Errorf("upstream received %d queries, want 1", got)
+	}
+
+	// 不同类型或不同客户端策略不合并
+	var wg2 sync.WaitGroup
+	other := newClientPolicy(ClientGroup{Name: "kids", Upstreams: []string{UpstreamGroupIntl}})
+	for _, c := range []struct {
+		qtype  uint16
+		policy *clientPolicy
+	}{{mdns.TypeA, defaultPolicy}, {mdns.TypeAAAA, defaultPolicy}, {mdns.TypeA, other}} {
+		wg2.Add(1)
+		go func(qtype uint16, policy *clientPolicy) {
+			defer wg2.Done()
+			req := new(mdns.Msg)
+			req.SetQuestion("www.example.com.", qtype)
+			if _, _, err := s.forward(context.Background(), req, policy, []string{addr}, "intl"); err != nil {
+				t.Error(err)
+			}
+		}(c.qtype, c.policy)
+	}
+	wg2.Wait()
+	if got := queries.Load(); got != 4 {
+		t.Errorf("upstream received %d queries, want 4", got)
+	}
+}
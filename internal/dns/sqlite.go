@@ -123,6 +123,11 @@ func (sm *SQLiteManager) initDatabase() error {
 		return err
 	}
 
+	// 升级旧版本的表结构
+	if err := sm.migrateTables(); err != nil {
+		return err
+	}
+
 	// 创建索引
 	if err := sm.createIndexes(); err != nil {
 		return err
@@ -145,7 +150,8 @@ func (sm *SQLiteManager) createTables() error {
 			expire_at INTEGER,
 			created_at INTEGER,
 			access_count INTEGER DEFAULT 0,
-			last_access INTEGER
+			last_access INTEGER,
+			format_version INTEGER DEFAULT 0
 		)`,
 
 		`CREATE TABLE IF NOT EXISTS query_logs (
@@ -157,14 +163,37 @@ func (sm *SQLiteManager) createTables() error {
 			client_ip TEXT,
 			query_type TEXT,
 			response_code TEXT,
+			client_group TEXT,
+			answers TEXT,
+			upstream TEXT,
+			cached INTEGER DEFAULT 0,
+			blocked INTEGER DEFAULT 0,
 			created_at INTEGER DEFAULT (strftime('%s', 'now'))
 		)`,
 
 		`CREATE TABLE IF NOT EXISTS dns_rules (
 			category TEXT NOT NULL,
+			rule_type TEXT NOT NULL DEFAULT 'domain',
 			domain TEXT NOT NULL,
 			created_at INTEGER DEFAULT (strftime('%s', 'now')),
-			PRIMARY KEY (category, domain)
+			PRIMARY KEY (category, rule_type, domain)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS local_records (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			value TEXT NOT NULL,
+			ttl INTEGER NOT NULL DEFAULT 300,
+			created_at INTEGER DEFAULT (strftime('%s', 'now')),
+			updated_at INTEGER DEFAULT (strftime('%s', 'now'))
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS client_groups (
+			name TEXT PRIMARY KEY,
+			config TEXT NOT NULL,
+			created_at INTEGER DEFAULT (strftime('%s', 'now')),
+			updated_at INTEGER DEFAULT (strftime('%s', 'now'))
 		)`,
 
 		`CREATE TABLE IF NOT EXISTS stats (
@@ -207,6 +236,35 @@ func (sm *SQLiteManager) createTables() error {
 			UNIQUE(source_id, domain)
 		)`,
 
+		`CREATE TABLE IF NOT EXISTS gfw_candidates (
+			domain TEXT PRIMARY KEY,
+			hits INTEGER NOT NULL DEFAULT 0,
+			last_ip TEXT,
+			first_seen INTEGER NOT NULL,
+			last_seen INTEGER NOT NULL
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS domain_outcomes (
+			domain TEXT PRIMARY KEY,
+			china_ok INTEGER NOT NULL DEFAULT 0,
+			china_failed INTEGER NOT NULL DEFAULT 0,
+			china_foreign_ip INTEGER NOT NULL DEFAULT 0,
+			intl_used INTEGER NOT NULL DEFAULT 0,
+			streak INTEGER NOT NULL DEFAULT 0,
+			streak_vote TEXT NOT NULL DEFAULT '',
+			updated_at INTEGER NOT NULL
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS learned_rules (
+			domain TEXT PRIMARY KEY,
+			category TEXT NOT NULL,
+			status TEXT NOT NULL,
+			observations INTEGER NOT NULL DEFAULT 0,
+			provenance TEXT,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)`,
+
 		`CREATE TABLE IF NOT EXISTS subscription_stats (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			source_id INTEGER NOT NULL,
@@ -228,6 +286,161 @@ func (sm *SQLiteManager) createTables() error {
 	return nil
 }
 
+// migrateTables 为旧版本数据库补齐新增的列
+func (sm *SQLiteManager) migrateTables() error {
+	if err := sm.rebuildRulesTable(); err != nil {
+		return fmt.Errorf("升级表 dns_rules 失败: %v", err)
+	}
+
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"query_logs", "client_group", "TEXT"},
+		{"query_logs", "answers", "TEXT"},
+		{"query_logs", "upstream", "TEXT"},
+		{"query_logs", "cached", "INTEGER DEFAULT 0"},
+		{"query_logs", "blocked", "INTEGER DEFAULT 0"},
+		{"dns_cache", "format_version", "INTEGER DEFAULT 0"},
+	}
+
+	for _, c := range columns {
+		if err := sm.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("升级表 %s 失败: %v", c.table, err)
+		}
+	}
+
+	// 缓存键格式变化后旧条目不会再被命中，直接清理
+	if _, err := sm.db.Exec("DELETE FROM dns_cache WHERE format_version != ?", cacheFormatVersion); err != nil {
+		return fmt.Errorf("清理旧版本缓存失败: %v", err)
+	}
+	return nil
+}
+
+// tableColumns 返回表的列名及其在主键中的位置（0 表示不属于主键）
+func (sm *SQLiteManager) tableColumns(table string) (map[string]int, error) {
+	rows, err := sm.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]int)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = pk
+	}
+	return columns, rows.Err()
+}
+
+// addColumnIfMissing 表中不存在该列时追加
+func (sm *SQLiteManager) addColumnIfMissing(table, column, definition string) error {
+	columns, err := sm.tableColumns(table)
+	if err != nil {
+		return err
+	}
+	if _, ok := columns[column]; ok {
+		return nil
+	}
+	_, err = sm.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// rebuildRulesTable 旧版本的 dns_rules 以 (category, domain) 为主键，
+// 同一分类下 full:x.com 与 x.com 会互相覆盖。主键不含 rule_type 时新建表、
+// 按新语法重新解析并复制规则，再替换旧表
+func (sm *SQLiteManager) rebuildRulesTable() error {
+	columns, err := sm.tableColumns("dns_rules")
+	if err != nil {
+		return err
+	}
+	if columns["rule_type"] > 0 {
+		return nil
+	}
+
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`CREATE TABLE dns_rules_new (
+		category TEXT NOT NULL,
+		rule_type TEXT NOT NULL DEFAULT 'domain',
+		domain TEXT NOT NULL,
+		created_at INTEGER DEFAULT (strftime('%s', 'now')),
+		PRIMARY KEY (category, rule_type, domain)
+	)`); err != nil {
+		return err
+	}
+
+	query := "SELECT category, 'domain', domain, created_at FROM dns_rules"
+	if _, ok := columns["rule_type"]; ok {
+		query = "SELECT category, rule_type, domain, created_at FROM dns_rules"
+	}
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	type oldRule struct {
+		category, ruleType, domain string
+		createdAt                  sql.NullInt64
+	}
+	var old []oldRule
+	for rows.Next() {
+		var r oldRule
+		if err := rows.Scan(&r.category, &r.ruleType, &r.domain, &r.createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		old = append(old, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO dns_rules_new (category, rule_type, domain, created_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range old {
+		raw := r.domain
+		if r.ruleType != RuleTypeDomain {
+			raw = DomainRule{Type: r.ruleType, Value: r.domain}.String()
+		}
+		rule, err := ParseDomainRule(raw)
+		if err != nil {
+			log.Printf("迁移时跳过无效规则 %s: %v", raw, err)
+			continue
+		}
+		if _, err := stmt.Exec(r.category, rule.Type, rule.Value, r.createdAt); err != nil {
+			return err
+		}
+	}
+
+	for _, q := range []string{
+		"DROP TABLE dns_rules",
+		"ALTER TABLE dns_rules_new RENAME TO dns_rules",
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("已重建 dns_rules 表，迁移 %d 条规则", len(old))
+	return nil
+}
+
 // createIndexes 创建性能索引
 func (sm *SQLiteManager) createIndexes() error {
 	indexes := []string{
@@ -236,7 +449,10 @@ func (sm *SQLiteManager) createIndexes() error {
 		"CREATE INDEX IF NOT EXISTS idx_logs_timestamp ON query_logs(timestamp)",
 		"CREATE INDEX IF NOT EXISTS idx_logs_name ON query_logs(name)",
 		"CREATE INDEX IF NOT EXISTS idx_logs_route ON query_logs(route)",
+		"CREATE INDEX IF NOT EXISTS idx_logs_client ON query_logs(client_ip)",
 		"CREATE INDEX IF NOT EXISTS idx_rules_category ON dns_rules(category)",
+		"CREATE INDEX IF NOT EXISTS idx_rules_type ON dns_rules(rule_type)",
+		"CREATE INDEX IF NOT EXISTS idx_local_records_name ON local_records(name)",
 		"CREATE INDEX IF NOT EXISTS idx_performance_timestamp ON performance_metrics(timestamp)",
 		"CREATE INDEX IF NOT EXISTS idx_performance_operation ON performance_metrics(operation)",
 		"CREATE INDEX IF NOT EXISTS idx_subscription_sources_category ON subscription_sources(category)",
@@ -244,6 +460,8 @@ func (sm *SQLiteManager) createIndexes() error {
 		"CREATE INDEX IF NOT EXISTS idx_subscription_rules_source ON subscription_rules(source_id)",
 		"CREATE INDEX IF NOT EXISTS idx_subscription_rules_category ON subscription_rules(category)",
 		"CREATE INDEX IF NOT EXISTS idx_subscription_stats_source ON subscription_stats(source_id)",
+		"CREATE INDEX IF NOT EXISTS idx_gfw_candidates_hits ON gfw_candidates(hits)",
+		"CREATE INDEX IF NOT EXISTS idx_learned_rules_status ON learned_rules(status)",
 	}
 
 	for _, index := range indexes {
@@ -292,8 +510,8 @@ func (sm *SQLiteManager) SaveCache(cache map[string]*CacheEntry) error {
 	// 准备语句
 	stmt, err := tx.Prepare(`
 		INSERT OR REPLACE INTO dns_cache 
-		(key, response, expire_at, created_at, access_count, last_access) 
-		VALUES (?, ?, ?, ?, ?, ?)
+		(key, response, expire_at, created_at, access_count, last_access, format_version) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return fmt.Errorf("准备语句失败: %v", err)
@@ -312,9 +530,10 @@ func (sm *SQLiteManager) SaveCache(cache map[string]*CacheEntry) error {
 			key,
 			responseData,
 			entry.ExpireAt.Unix(),
-			time.Now().Unix(),
+			entry.StoredAt.Unix(),
 			entry.Hits,
 			time.Now().Unix(),
+			cacheFormatVersion,
 		)
 		if err != nil {
 			log.Printf("插入缓存记录失败: %v", err)
@@ -338,9 +557,9 @@ func (sm *SQLiteManager) LoadCache() (map[string]*CacheEntry, error) {
 	rows, err := sm.db.Query(`
 		SELECT key, response, expire_at, created_at, access_count, last_access 
 		FROM dns_cache 
-		WHERE expire_at > ? 
+		WHERE expire_at > ? AND format_version = ?
 		ORDER BY last_access DESC
-	`, time.Now().Unix())
+	`, time.Now().Unix(), cacheFormatVersion)
 	if err != nil {
 		return nil, fmt.Errorf("查询缓存失败: %v", err)
 	}
@@ -370,6 +589,7 @@ func (sm *SQLiteManager) LoadCache() (map[string]*CacheEntry, error) {
 
 		// 创建缓存条目
 		entry := &CacheEntry{
+			StoredAt: time.Unix(createdAt, 0),
 			ExpireAt: time.Unix(expireAt, 0),
 			Hits:     accessCount,
 		}
@@ -402,8 +622,9 @@ func (sm *SQLiteManager) SaveLogs(logs []QueryLog) error {
 	// 准备语句
 	stmt, err := tx.Prepare(`
 		INSERT INTO query_logs 
-		(name, route, latency, timestamp, client_ip, query_type, response_code) 
-		VALUES (?, ?, ?, ?, ?, ?, ?)
+		(name, route, latency, timestamp, client_ip, query_type, response_code,
+		 client_group, answers, upstream, cached, blocked) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`)
 	if err != nil {
 		return fmt.Errorf("准备语句失败: %v", err)
@@ -412,14 +633,20 @@ func (sm *SQLiteManager) SaveLogs(logs []QueryLog) error {
 
 	// 批量执行
 	for _, log := range logs {
+		answers, _ := json.Marshal(log.Answers)
 		_, err = stmt.Exec(
 			log.Name,
 			log.Route,
 			log.Latency,
 			log.Time.Unix(),
-			"",        // client_ip (可选)
-			"A",       // query_type (可选)
-			"NOERROR", // response_code (可选)
+			log.ClientIP,
+			log.QueryType,
+			log.Rcode,
+			log.ClientGroup,
+			string(answers),
+			log.Upstream,
+			log.Cached,
+			log.Blocked,
 		)
 		if err != nil {
 			fmt.Printf("插入日志记录失败: %v", err)
@@ -447,7 +674,8 @@ func (sm *SQLiteManager) LoadLogs() ([]QueryLog, error) {
 	}
 
 	rows, err := sm.db.Query(`
-		SELECT name, route, latency, timestamp 
+		SELECT name, route, latency, timestamp, client_ip, query_type, response_code,
+		       client_group, answers, upstream, cached, blocked
 		FROM query_logs 
 		ORDER BY timestamp DESC 
 		LIMIT ?
@@ -465,19 +693,35 @@ func (sm *SQLiteManager) LoadLogs() ([]QueryLog, error) {
 			route     string
 			latency   int64
 			timestamp int64
+
+			clientIP, queryType, rcode     sql.NullString
+			clientGroup, answers, upstream sql.NullString
+			cached, blocked                sql.NullBool
 		)
 
-		if err := rows.Scan(&name, &route, &latency, &timestamp); err != nil {
+		if err := rows.Scan(&name, &route, &latency, &timestamp, &clientIP, &queryType, &rcode,
+			&clientGroup, &answers, &upstream, &cached, &blocked); err != nil {
 			log.Printf("扫描日志记录失败: %v", err)
 			continue
 		}
 
-		logs = append(logs, QueryLog{
-			Name:    name,
-			Route:   route,
-			Latency: latency,
-			Time:    time.Unix(timestamp, 0),
-		})
+		entry := QueryLog{
+			Name:        name,
+			Route:       route,
+			Latency:     latency,
+			Time:        time.Unix(timestamp, 0),
+			ClientIP:    clientIP.String,
+			ClientGroup: clientGroup.String,
+			QueryType:   queryType.String,
+			Rcode:       rcode.String,
+			Upstream:    upstream.String,
+			Cached:      cached.Bool,
+			Blocked:     blocked.Bool,
+		}
+		if answers.String != "" {
+			_ = json.Unmarshal([]byte(answers.String), &entry.Answers)
+		}
+		logs = append(logs, entry)
 	}
 
 	return logs, nil
@@ -575,8 +819,8 @@ func (sm *SQLiteManager) SaveRules(rules map[string][]string) error {
 
 	// 准备语句
 	stmt, err := tx.Prepare(`
-		INSERT INTO dns_rules (category, domain, created_at) 
-		VALUES (?, ?, ?)
+		INSERT OR REPLACE INTO dns_rules (category, rule_type, domain, created_at) 
+		VALUES (?, ?, ?, ?)
 	`)
 	if err != nil {
 		return fmt.Errorf("准备语句失败: %v", err)
@@ -586,7 +830,12 @@ func (sm *SQLiteManager) SaveRules(rules map[string][]string) error {
 	// 批量插入
 	for category, domains := range rules {
 		for _, domain := range domains {
-			_, err = stmt.Exec(category, domain, time.Now().Unix())
+			rule, err := ParseDomainRule(domain)
+			if err != nil {
+				log.Printf("跳过无效规则 %s: %v", domain, err)
+				continue
+			}
+			_, err = stmt.Exec(category, rule.Type, rule.Value, time.Now().Unix())
 			if err != nil {
 				log.Printf("插入规则失败: %v", err)
 				continue
@@ -606,7 +855,7 @@ func (sm *SQLiteManager) LoadRules() (map[string][]string, error) {
 	sm.mutex.RLock()
 	defer sm.mutex.RUnlock()
 
-	rows, err := sm.db.Query(`SELECT category, domain FROM dns_rules ORDER BY category, domain`)
+	rows, err := sm.db.Query(`SELECT category, rule_type, domain FROM dns_rules ORDER BY category, rule_type, domain`)
 	if err != nil {
 		return nil, fmt.Errorf("查询规则失败: %v", err)
 	}
@@ -615,9 +864,9 @@ func (sm *SQLiteManager) LoadRules() (map[string][]string, error) {
 	rules := make(map[string][]string)
 
 	for rows.Next() {
-		var category, domain string
+		var category, ruleType, domain string
 
-		if err := rows.Scan(&category, &domain); err != nil {
+		if err := rows.Scan(&category, &ruleType, &domain); err != nil {
 			log.Printf("扫描规则记录失败: %v", err)
 			continue
 		}
@@ -625,7 +874,7 @@ func (sm *SQLiteManager) LoadRules() (map[string][]string, error) {
 		if rules[category] == nil {
 			rules[category] = make([]string, 0)
 		}
-		rules[category] = append(rules[category], domain)
+		rules[category] = append(rules[category], DomainRule{Type: ruleType, Value: domain}.String())
 	}
 
 	return rules, nil
@@ -815,6 +1064,328 @@ func (sm *SQLiteManager) recordPerformance(operation string, duration time.Durat
 
 // ==================== 订阅源管理方法 ====================
 
+// SaveLocalRecord 保存本地记录（ID 为 0 时新增）
+func (sm *SQLiteManager) SaveLocalRecord(rec *LocalRecord) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if rec.ID == 0 {
+		result, err := sm.db.Exec(`
+			INSERT INTO local_records (name, type, value, ttl, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, rec.Name, rec.Type, rec.Value, rec.TTL, rec.CreatedAt, rec.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("新增本地记录失败: %v", err)
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("获取本地记录ID失败: %v", err)
+		}
+		rec.ID = int(id)
+		return nil
+	}
+
+	_, err := sm.db.Exec(`
+		UPDATE local_records
+		SET name = ?, type = ?, value = ?, ttl = ?, updated_at = ?
+		WHERE id = ?
+	`, rec.Name, rec.Type, rec.Value, rec.TTL, rec.UpdatedAt, rec.ID)
+	if err != nil {
+		return fmt.Errorf("更新本地记录失败: %v", err)
+	}
+	return nil
+}
+
+// GetLocalRecords 获取所有本地记录
+func (sm *SQLiteManager) GetLocalRecords() ([]*LocalRecord, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	rows, err := sm.db.Query(`
+		SELECT id, name, type, value, ttl, created_at, updated_at
+		FROM local_records
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("查询本地记录失败: %v", err)
+	}
+	defer rows.Close()
+
+	var records []*LocalRecord
+	for rows.Next() {
+		rec := &LocalRecord{}
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Type, &rec.Value, &rec.TTL, &rec.CreatedAt, &rec.UpdatedAt); err != nil {
+			log.Printf("扫描本地记录失败: %v", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// DeleteLocalRecord 删除本地记录
+func (sm *SQLiteManager) DeleteLocalRecord(id int) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if _, err := sm.db.Exec("DELETE FROM local_records WHERE id = ?", id); err != nil {
+		return fmt.Errorf("删除本地记录失败: %v", err)
+	}
+	return nil
+}
+
+// SaveClientGroup 保存客户端组（同名覆盖）
+func (sm *SQLiteManager) SaveClientGroup(group ClientGroup) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	data, err := json.Marshal(group)
+	if err != nil {
+		return fmt.Errorf("序列化客户端组失败: %v", err)
+	}
+
+	now := time.Now().Unix()
+	_, err = sm.db.Exec(`
+		INSERT INTO client_groups (name, config, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET config = excluded.config, updated_at = excluded.updated_at
+	`, group.Name, string(data), now, now)
+	if err != nil {
+		return fmt.Errorf("保存客户端组失败: %v", err)
+	}
+	return nil
+}
+
+// GetClientGroups 获取所有客户端组
+func (sm *SQLiteManager) GetClientGroups() ([]ClientGroup, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	rows, err := sm.db.Query(`SELECT name, config FROM client_groups ORDER BY created_at, name`)
+	if err != nil {
+		return nil, fmt.Errorf("查询客户端组失败: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []ClientGroup
+	for rows.Next() {
+		var name, data string
+		if err := rows.Scan(&name, &data); err != nil {
+			log.Printf("扫描客户端组失败: %v", err)
+			continue
+		}
+		var group ClientGroup
+		if err := json.Unmarshal([]byte(data), &group); err != nil {
+			log.Printf("解析客户端组 %s 失败: %v", name, err)
+			continue
+		}
+		group.Name = name
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// DeleteClientGroup 删除客户端组
+func (sm *SQLiteManager) DeleteClientGroup(name string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if _, err := sm.db.Exec("DELETE FROM client_groups WHERE name = ?", name); err != nil {
+		return fmt.Errorf("删除客户端组失败: %v", err)
+	}
+	return nil
+}
+
+// RecordGFWCandidate 记录一次 china 路由被污染的域名，累计命中次数
+func (sm *SQLiteManager) RecordGFWCandidate(domain, ip string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	now := time.Now().Unix()
+	_, err := sm.db.Exec(`
+		INSERT INTO gfw_candidates (domain, hits, last_ip, first_seen, last_seen)
+		VALUES (?, 1, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET hits = hits + 1, last_ip = excluded.last_ip, last_seen = excluded.last_seen
+	`, domain, ip, now, now)
+	if err != nil {
+		return fmt.Errorf("保存 GFW 学习候选失败: %v", err)
+	}
+	return nil
+}
+
+// GetGFWCandidates 获取 GFW 学习候选，按命中次数降序，limit <= 0 表示不限制
+func (sm *SQLiteManager) GetGFWCandidates(limit int) ([]GFWCandidate, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := sm.db.Query(`
+		SELECT domain, hits, COALESCE(last_ip, ''), first_seen, last_seen
+		FROM gfw_candidates
+		ORDER BY hits DESC, last_seen DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("查询 GFW 学习候选失败: %v", err)
+	}
+	defer rows.Close()
+
+	var candidates []GFWCandidate
+	for rows.Next() {
+		var c GFWCandidate
+		var first, last int64
+		if err := rows.Scan(&c.Domain, &c.Hits, &c.LastIP, &first, &last); err != nil {
+			log.Printf("扫描 GFW 学习候选失败: %v", err)
+			continue
+		}
+		c.FirstSeen = time.Unix(first, 0)
+		c.LastSeen = time.Unix(last, 0)
+		candidates = append(candidates, c)
+	}
+	return candidates, nil
+}
+
+// domainOutcomeQuery 查询域名的累计解析结果，污染次数取自 gfw_candidates，不在 domain_outcomes 中重复记录
+const domainOutcomeQuery = `
+	SELECT o.china_ok, o.china_failed, COALESCE(c.hits, 0), o.china_foreign_ip, o.intl_used, o.streak, o.streak_vote, o.updated_at
+	FROM domain_outcomes o LEFT JOIN gfw_candidates c ON c.domain = o.domain
+	WHERE o.domain = ?`
+
+// scanDomainOutcome 读取 domainOutcomeQuery 的结果
+func scanDomainOutcome(row *sql.Row, out *DomainOutcome) error {
+	var updated int64
+	err := row.Scan(&out.ChinaOK, &out.ChinaFailed, &out.ChinaPoisoned, &out.ChinaForeign, &out.IntlUsed, &out.Streak, &out.StreakVote, &updated)
+	if err == nil {
+		out.UpdatedAt = time.Unix(updated, 0)
+	}
+	return err
+}
+
+// RecordDomainOutcome 累计域名的一次解析结果并更新连续一致观测，vote 为空时不影响连续计数。
+// 被污染的次数由 RecordGFWCandidate 写入 gfw_candidates，这里只计入连续观测
+func (sm *SQLiteManager) RecordDomainOutcome(domain, outcome string, intlUsed bool, vote string) (*DomainOutcome, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("开始事务失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	out := &DomainOutcome{Domain: domain}
+	err = scanDomainOutcome(tx.QueryRow(domainOutcomeQuery, domain), out)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("查询域名解析结果失败: %v", err)
+	}
+
+	switch outcome {
+	case OutcomeChinaOK:
+		out.ChinaOK++
+	case OutcomeChinaFailed:
+		out.ChinaFailed++
+	case OutcomeChinaForeignIP:
+		out.ChinaForeign++
+	}
+	if intlUsed {
+		out.IntlUsed++
+	}
+	if vote != "" {
+		if vote == out.StreakVote {
+			out.Streak++
+		} else {
+			out.StreakVote = vote
+			out.Streak = 1
+		}
+	}
+	out.UpdatedAt = time.Now()
+
+	_, err = tx.Exec(`
+		INSERT INTO domain_outcomes (domain, china_ok, china_failed, china_foreign_ip, intl_used, streak, streak_vote, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET
+			china_ok = excluded.china_ok, china_failed = excluded.china_failed,
+			china_foreign_ip = excluded.china_foreign_ip, intl_used = excluded.intl_used,
+			streak = excluded.streak, streak_vote = excluded.streak_vote, updated_at = excluded.updated_at
+	`, domain, out.ChinaOK, out.ChinaFailed, out.ChinaForeign, out.IntlUsed, out.Streak, out.StreakVote, out.UpdatedAt.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("保存域名解析结果失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("提交事务失败: %v", err)
+	}
+	return out, nil
+}
+
+// GetDomainOutcome 获取域名的累计解析结果
+func (sm *SQLiteManager) GetDomainOutcome(domain string) (*DomainOutcome, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	out := &DomainOutcome{Domain: domain}
+	err := scanDomainOutcome(sm.db.QueryRow(domainOutcomeQuery, domain), out)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("没有域名 %s 的解析记录", domain)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询域名解析结果失败: %v", err)
+	}
+	return out, nil
+}
+
+// SaveLearnedRule 保存学习规则（同域名覆盖）
+func (sm *SQLiteManager) SaveLearnedRule(rule *LearnedRule) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	_, err := sm.db.Exec(`
+		INSERT INTO learned_rules (domain, category, status, observations, provenance, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET
+			category = excluded.category, status = excluded.status, observations = excluded.observations,
+			provenance = excluded.provenance, updated_at = excluded.updated_at
+	`, rule.Domain, rule.Category, rule.Status, rule.Observations, rule.Provenance, rule.CreatedAt.Unix(), rule.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("保存学习规则失败: %v", err)
+	}
+	return nil
+}
+
+// GetLearnedRules 获取所有学习规则
+func (sm *SQLiteManager) GetLearnedRules() ([]LearnedRule, error) {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	rows, err := sm.db.Query(`
+		SELECT domain, category, status, observations, COALESCE(provenance, ''), created_at, updated_at
+		FROM learned_rules
+		ORDER BY updated_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("查询学习规则失败: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []LearnedRule
+	for rows.Next() {
+		var r LearnedRule
+		var created, updated int64
+		if err := rows.Scan(&r.Domain, &r.Category, &r.Status, &r.Observations, &r.Provenance, &created, &updated); err != nil {
+			log.Printf("扫描学习规则失败: %v", err)
+			continue
+		}
+		r.CreatedAt = time.Unix(created, 0)
+		r.UpdatedAt = time.Unix(updated, 0)
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
 // SaveSubscriptionSource 保存订阅源
 func (sm *SQLiteManager) SaveSubscriptionSource(source *SubscriptionSource) error {
 	sm.mutex.Lock()
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// 上游选择策略
+const (
+	StrategySequential = "sequential"  // 按配置顺序依次尝试
+	StrategyParallel   = "parallel"    // 同时查询全部上游，第一个有效应答胜出
+	StrategyFastest    = "fastest"     // 按延迟 EWMA 从快到慢依次尝试
+	StrategyRoundRobin = "round-robin" // 轮询起始上游，失败时依次尝试其余上游
+)
+
+// latencyEWMAAlpha 延迟 EWMA 的平滑系数，越大越偏向最近的测量值
+const latencyEWMAAlpha = 0.3
+
+// IsValidUpstreamStrategy 判断上游选择策略是否有效
+func IsValidUpstreamStrategy(strategy string) bool {
+	switch strategy {
+	case StrategySequential, StrategyParallel, StrategyFastest, StrategyRoundRobin:
+		return true
+	}
+	return false
+}
+
+// upstreamGroupOf 路由对应的上游组配置名，如 intl-fallback 使用 intl 的配置
+func upstreamGroupOf(target string) string {
+	return strings.TrimSuffix(target, "-fallback")
+}
+
+// attempt 向单个上游发送一次查询并记录延迟与健康状态；
+// ctx 被取消（并行竞速中已有应答）时不计为失败
+func (s *Server) attempt(ctx context.Context, req *mdns.Msg, u upstream, target string, timeout time.Duration) (*mdns.Msg, error) {
+	actx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	// 这里未直接支持 socks5，建议使用 mihomo 暴露本地 DNS 端口，或在系统层做 socks5 透明转发
+	resp, err := s.exchangeFiltered(actx, req, u, target)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	elapsed := time.Since(start)
+	upstreamLatency.WithLabelValues(target).Observe(elapsed.Seconds())
+	if err == nil && resp != nil {
+		s.recordSuccess(u, elapsed)
+		return resp, nil
+	}
+	if err == nil {
+		err = errors.New("empty response")
+	}
+	s.recordFailure(u, target, err)
+	upstreamFailures.WithLabelValues(target).Inc()
+	s.observeLatency(u, timeout)
+	return nil, err
+}
+
+// forwardParallel 同时查询全部上游，返回第一个有效应答（不含投毒地址）；
+// 全部无效时返回任一收到的应答（如 SERVFAIL），都失败时返回最后一个错误
+func (s *Server) forwardParallel(ctx context.Context, req *mdns.Msg, cands []upstream, target string, timeout time.Duration) (*mdns.Msg, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp *mdns.Msg
+		addr string
+		err  error
+	}
+	results := make(chan result, len(cands))
+	for _, u := range cands {
+		go func(u upstream) {
+			resp, err := s.attempt(ctx, req.Copy(), u, target, timeout)
+			results <- result{resp: resp, addr: u.Addr, err: err}
+		}(u)
+	}
+
+	var fallback *result
+	var lastErr error
+	for range cands {
+		r := <-results
+		if r.err != nil {
+			lastErr = r.err
+			continue
+		}
+		if r.resp.Rcode != mdns.RcodeServerFailure && r.resp.Rcode != mdns.RcodeRefused && !s.poison.rejects(target, r.resp) {
+			return r.resp, r.addr, nil
+		}
+		if fallback == nil {
+			fallback = &r
+		}
+	}
+	if fallback != nil {
+		return fallback.resp, fallback.addr, nil
+	}
+	return nil, "", lastErr
+}
+
+// sortByLatency 按延迟 EWMA 从快到慢排序，尚未测量的上游排在最前以便探测
+func (s *Server) sortByLatency(cands []upstream) {
+	s.healthMu.Lock()
+	ewma := make(map[string]time.Duration, len(cands))
+	for _, u := range cands {
+		if st := s.upstreamHealth[u.key()]; st != nil {
+			ewma[u.key()] = st.ewma
+		}
+	}
+	s.healthMu.Unlock()
+	sort.SliceStable(cands, func(i, j int) bool { return ewma[cands[i].key()] < ewma[cands[j].key()] })
+}
+
+// rotate 按路由轮询起始上游
+func (s *Server) rotate(target string, cands []upstream) []upstream {
+	v, _ := s.roundRobin.LoadOrStore(target, new(atomic.Uint64))
+	start := int((v.(*atomic.Uint64).Add(1) - 1) % uint64(len(cands)))
+	return append(cands[start:len(cands):len(cands)], cands[:start]...)
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// newStrategyServer 创建 intl 组使用指定策略与单次尝试超时的服务器
+func newStrategyServer(strategy string, timeout time.Duration) *Server {
+	cfg := &Config{}
+	cfg.Upstreams.Options = map[string]UpstreamGroupOptions{
+		UpstreamGroupIntl: {Strategy: strategy, TimeoutMs: int(timeout / time.Millisecond)},
+	}
+	return newTestServer(cfg)
+}
+
+func forwardA(t *testing.T, s *Server, ups []string) (*mdns.Msg, string, time.Duration) {
+	t.Helper()
+	req := new(mdns.Msg)
+	req.SetQuestion("www.example.com.", mdns.TypeA)
+	start := time.Now()
+	resp, addr, err := s.forwardUpstreams(context.Background(), req, ups, "intl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, addr, time.Since(start)
+}
+
+func TestStrategySequentialAttemptTimeout(t *testing.T) {
+	s := newStrategyServer(StrategySequential, 100*time.Millisecond)
+	slow, slowQueries := countingUpstream(t, "192.0.2.1", 400*time.Millisecond)
+	fast, _ := countingUpstream(t, "192.0.2.2", 0)
+
+	// 第一个上游超过单次尝试超时后改用下一个
+	_, addr, elapsed := forwardA(t, s, []string{slow, fast})
+	if addr != fast {
+		t.Errorf("answered by %s, want %s", addr, fast)
+	}
+	if slowQueries.Load() != 1 {
+		t.Errorf("slow upstream received %d queries, want 1", slowQueries.Load())
+	}
+	if elapsed > 300*time.Millisecond {
+		t.Errorf("took %v, want about one attempt timeout", elapsed)
+	}
+}
+
+func TestStrategyParallel(t *testing.T) {
+	s := newStrategyServer(StrategyParallel, time.Second)
+	slow, _ := countingUpstream(t, "192.0.2.1", 300*time.Millisecond)
+	fast, _ := countingUpstream(t, "192.0.2.2", 0)
+
+	_, addr, elapsed := forwardA(t, s, []string{slow, fast})
+	if addr != fast {
+		t.Errorf("answered by %s, want %s", addr, fast)
+	}
+	if elapsed > 250*time.Millisecond {
+		t.Errorf("took %v, want the fastest upstream's latency", elapsed)
+	}
+}
+
+func TestStrategyFastest(t *testing.T) {
+	s := newStrategyServer(StrategyFastest, time.Second)
+	a, aQueries := countingUpstream(t, "192.0.2.1", 0)
+	b, bQueries := countingUpstream(t, "192.0.2.2", 0)
+
+	// 延迟 EWMA 较低的上游优先
+	s.recordSuccess(parseUpstream(a), 200*time.Millisecond)
+	s.recordSuccess(parseUpstream(b), 5*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, addr, _ := forwardA(t, s, []string{a, b}); addr != b {
+			t.Errorf("answered by %s, want %s", addr, b)
+		}
+	}
+	if aQueries.Load() != 0 || bQueries.Load() != 3 {
+		t.Errorf("queries = %d/%d, want 0/3", aQueries.Load(), bQueries.Load())
+	}
+}
+
+func TestStrategyRoundRobin(t *testing.T) {
+	s := newStrategyServer(StrategyRoundRobin, time.Second)
+	a, aQueries := countingUpstream(t, "192.0.2.1", 0)
+	b, bQueries := countingUpstream(t, "192.0.2.2", 0)
+
+	for i := 0; i < 4; i++ {
+		forwardA(t, s, []string{a, b})
+	}
+	if aQueries.Load() != 2 || bQueries.Load() != 2 {
+		t.Errorf("queries = %d/%d, want 2/2", aQueries.Load(), bQueries.Load())
+	}
+}
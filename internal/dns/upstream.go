@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// 上游协议
+const (
+	protoUDP   = "udp"
+	protoTCP   = "tcp"
+	protoTLS   = "tls"
+	protoHTTPS = "https"
+	protoQUIC  = "quic"
+
+	protoRecursive = "recursive" // 内置递归解析
+)
+
+// defaultUpstreamTimeout 单次上游请求超时
+const defaultUpstreamTimeout = 3 * time.Second
+
+// defaultRecursiveTimeout recursive 上游组的默认超时
+const defaultRecursiveTimeout = 10 * time.Second
+
+// upstream 解析后的上游地址
+type upstream struct {
+	Addr     string // 配置中的原始地址
+	Proto    string // udp / tcp / tls / https / quic
+	Endpoint string // host:port，DoH 为完整 URL
+	UseGET   bool   // DoH 是否使用 GET 方式
+
+	// DoT / DoQ 证书校验参数
+	ServerName string   // TLS SNI 及证书校验主机名
+	SPKIPins   []string // 证书公钥 SHA-256 指纹（base64），任一匹配即通过
+}
+
+// key 上游在健康状态表中的唯一标识
+func (u upstream) key() string {
+	return u.Proto + "|" + u.Endpoint
+}
+
+// parseUpstream 解析上游地址，支持以下格式：
+//
+//	223.5.5.5 / 223.5.5.5:53        普通 DNS (UDP)
+//	tcp://223.5.5.5:53               DNS over TCP
+//	tls://1.1.1.1:853                DNS over TLS
+//	tls://1.1.1.1:853?sni=one.one.one.one&spki=<base64> 指定 SNI / 固定公钥
+//	quic://dns.adguard-dns.com:853   DNS over QUIC (RFC 9250)，参数同 tls://
+//	https://dns.google/dns-query     DNS over HTTPS (POST)
+//	https://dns.google/dns-query{?dns} DNS over HTTPS (GET，RFC 8484 URI 模板)
+//	recursive                        内置递归解析
+func parseUpstream(address string) upstream {
+	address = strings.TrimSpace(address)
+	u := upstream{Addr: address}
+
+	switch {
+	case address == RecursiveUpstream:
+		u.Proto = protoRecursive
+		u.Endpoint = RecursiveUpstream
+	case strings.HasPrefix(address, "https://"):
+		u.Proto = protoHTTPS
+		endpoint := address
+		if strings.HasSuffix(endpoint, "{?dns}") {
+			endpoint = strings.TrimSuffix(endpoint, "{?dns}")
+			u.UseGET = true
+		}
+		// 未指定路径时使用 RFC 8484 推荐的 /dns-query
+		if !strings.Contains(strings.TrimPrefix(endpoint, "https://"), "/") {
+			endpoint += "/dns-query"
+		}
+		u.Endpoint = endpoint
+	case strings.HasPrefix(address, "tls://"):
+		u.Proto = protoTLS
+		u.parseTLSParams(strings.TrimPrefix(address, "tls://"))
+	case strings.HasPrefix(address, "quic://"):
+		u.Proto = protoQUIC
+		u.parseTLSParams(strings.TrimPrefix(address, "quic://"))
+	case strings.HasPrefix(address, "tcp://"):
+		u.Proto = protoTCP
+		u.Endpoint = withDefaultPort(strings.TrimPrefix(address, "tcp://"), "53")
+	default:
+		u.Proto = protoUDP
+		u.Endpoint = withDefaultPort(strings.TrimPrefix(address, "udp://"), "53")
+	}
+	return u
+}
+
+// parseTLSParams 解析 host:port?sni=...&spki=... 形式的加密上游地址
+func (u *upstream) parseTLSParams(rest string) {
+	hostport, query, _ := strings.Cut(rest, "?")
+	u.Endpoint = withDefaultPort(hostport, "853")
+	u.ServerName, _, _ = net.SplitHostPort(u.Endpoint)
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return
+	}
+	if sni := params.Get("sni"); sni != "" {
+		u.ServerName = sni
+	}
+	for _, pin := range params["spki"] {
+		// base64 中的 '+' 在查询串中会被解码为空格
+		u.SPKIPins = append(u.SPKIPins, strings.ReplaceAll(pin, " ", "+"))
+	}
+}
+
+// withDefaultPort 地址未带端口时补全默认端口
+func withDefaultPort(hostport, port string) string {
+	if _, _, err := net.SplitHostPort(hostport); err == nil {
+		return hostport
+	}
+	return net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+}
+
+// exchange 按上游协议发送一次 DNS 查询，ctx 未设置截止时间时使用默认超时
+func (s *Server) exchange(ctx context.Context, req *mdns.Msg, u upstream) (*mdns.Msg, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultUpstreamTimeout)
+		defer cancel()
+	}
+
+	switch u.Proto {
+	case protoHTTPS:
+		return s.doh.exchange(ctx, req, u)
+	case protoTLS:
+		return s.dot.exchange(ctx, req, u)
+	case protoQUIC:
+		return s.doq.exchange(ctx, req, u)
+	case protoRecursive:
+		if s.recursor == nil {
+			return nil, fmt.Errorf("内置递归解析未启用")
+		}
+		return s.recursor.exchange(ctx, req)
+	case protoUDP, protoTCP:
+		deadline, _ := ctx.Deadline()
+		c := &mdns.Client{Net: u.Proto, Timeout: time.Until(deadline)}
+		resp, _, err := c.ExchangeContext(ctx, req, u.Endpoint)
+		return resp, err
+	default:
+		return nil, fmt.Errorf("不支持的上游协议: %s", u.Proto)
+	}
+}
@@ -2,6 +2,7 @@ package admin
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -57,10 +58,31 @@ func BindRoutes(r *chi.Mux, srv *dns.Server, cfg *dns.Config) {
 		pr.Delete("/api/rules/delete", api.deleteRule)
 		pr.Put("/api/rules/update", api.updateRule)
 		pr.Get("/api/rules/search", api.searchRules)
+		pr.Get("/api/rules/candidates", api.getGFWCandidates)
+		pr.Get("/api/rules/learned", api.getLearnedRules)
+		pr.Get("/api/rules/learned/{domain}", api.getDomainOutcome)
+		pr.Post("/api/rules/learned/{domain}/confirm", api.confirmLearnedRule)
+		pr.Post("/api/rules/learned/{domain}/reject", api.rejectLearnedRule)
+
+		// 本地记录API
+		pr.Get("/api/local-records", api.getLocalRecords)
+		pr.Post("/api/local-records", api.createLocalRecord)
+		pr.Put("/api/local-records/{id}", api.updateLocalRecord)
+		pr.Delete("/api/local-records/{id}", api.deleteLocalRecord)
+
+		// 客户端组策略API
+		pr.Get("/api/client-groups", api.getClientGroups)
+		pr.Post("/api/client-groups", api.saveClientGroup)
+		pr.Put("/api/client-groups/{name}", api.saveClientGroup)
+		pr.Delete("/api/client-groups/{name}", api.deleteClientGroup)
 
 		// 延迟统计相关API
 		pr.Get("/api/latency/stats", api.getLatencyStats)
 
+		// 上游健康状态
+		pr.Get("/api/upstreams", api.getUpstreams)
+		pr.Post("/api/upstreams/reset", api.resetUpstreams)
+
 		// 规则订阅相关API
 		pr.Get("/api/subscriptions/status", api.getSubscriptionStatus)
 		pr.Post("/api/subscriptions/update", api.updateSubscriptions)
@@ -182,8 +204,33 @@ func (a *Api) getLogs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	items := a.srv.GetLogs(limit)
-	_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+	q := r.URL.Query()
+	filter := dns.LogFilter{
+		Client:    q.Get("client"),
+		Name:      q.Get("name"),
+		QueryType: q.Get("qtype"),
+		Rcode:     q.Get("rcode"),
+		Route:     q.Get("route"),
+		Upstream:  q.Get("upstream"),
+		Answer:    q.Get("answer"),
+		Limit:     limit,
+	}
+	if v, err := strconv.ParseBool(q.Get("cached")); err == nil {
+		filter.Cached = &v
+	}
+	if v, err := strconv.ParseBool(q.Get("blocked")); err == nil {
+		filter.Blocked = &v
+	}
+	if since := q.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			filter.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			filter.Since = t
+		}
+	}
+
+	items := a.srv.QueryLogs(filter)
+	_ = json.NewEncoder(w).Encode(map[string]any{"items": items, "count": len(items)})
 }
 
 // 获取指标数据
@@ -374,20 +421,16 @@ func (a *Api) searchRules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 从DNS服务器获取当前规则
-	rules := a.srv.GetRules()
-
-	// 搜索匹配的规则
-	var results []map[string]string
-	for ruleType, domains := range rules {
-		for _, domain := range domains {
-			if strings.Contains(strings.ToLower(domain), strings.ToLower(query)) {
-				results = append(results, map[string]string{
-					"type":   ruleType,
-					"domain": domain,
-				})
-			}
-		}
+	// 按规则语义（精确/后缀/关键字/正则/通配）匹配，同时保留文本包含搜索
+	var results []map[string]any
+	for _, res := range a.srv.SearchRules(query) {
+		results = append(results, map[string]any{
+			"type":      res.Category,
+			"domain":    res.Rule,
+			"rule_type": res.RuleType,
+			"value":     res.Value,
+			"matched":   res.Matched,
+		})
 	}
 
 	response := map[string]any{
@@ -399,6 +442,271 @@ func (a *Api) searchRules(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(response)
 }
 
+// getLocalRecords 获取本地记录
+func (a *Api) getLocalRecords(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	records := a.srv.GetLocalRecordManager().List()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    records,
+		"count":   len(records),
+	})
+}
+
+// createLocalRecord 创建本地记录，立即生效
+func (a *Api) createLocalRecord(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	var record dns.LocalRecord
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		http.Error(w, "无效的请求数据", http.StatusBadRequest)
+		return
+	}
+
+	created, err := a.srv.GetLocalRecordManager().Add(record)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("创建本地记录失败: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "本地记录创建成功",
+		"data":    created,
+	})
+}
+
+// updateLocalRecord 更新本地记录（仅 API 来源的记录可修改）
+func (a *Api) updateLocalRecord(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "无效的记录ID", http.StatusBadRequest)
+		return
+	}
+
+	var record dns.LocalRecord
+	if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+		http.Error(w, "无效的请求数据", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := a.srv.GetLocalRecordManager().Update(id, record)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("更新本地记录失败: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "本地记录更新成功",
+		"data":    updated,
+	})
+}
+
+// deleteLocalRecord 删除本地记录
+func (a *Api) deleteLocalRecord(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "无效的记录ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.srv.GetLocalRecordManager().Delete(id); err != nil {
+		http.Error(w, fmt.Sprintf("删除本地记录失败: %v", err), http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "本地记录删除成功",
+	})
+}
+
+// getClientGroups 获取客户端组
+func (a *Api) getClientGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	groups := a.srv.GetClientPolicyManager().List()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    groups,
+		"count":   len(groups),
+	})
+}
+
+// saveClientGroup 新增或更新客户端组，立即生效
+func (a *Api) saveClientGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	var group dns.ClientGroup
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "无效的请求数据", http.StatusBadRequest)
+		return
+	}
+	// PUT 以路径中的名称为准
+	if name := chi.URLParam(r, "name"); name != "" {
+		group.Name = name
+	}
+
+	if err := a.srv.GetClientPolicyManager().Save(group); err != nil {
+		http.Error(w, fmt.Sprintf("保存客户端组失败: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "客户端组保存成功",
+		"data":    group,
+	})
+}
+
+// deleteClientGroup 删除客户端组
+func (a *Api) deleteClientGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	if err := a.srv.GetClientPolicyManager().Delete(chi.URLParam(r, "name")); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, dns.ErrConfigClientGroup) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("删除客户端组失败: %v", err), status)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "客户端组删除成功",
+	})
+}
+
+// getGFWCandidates 获取 china 路由被污染的 GFW 学习候选域名
+func (a *Api) getGFWCandidates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+
+	candidates, err := a.srv.GetGFWCandidates(limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("获取 GFW 学习候选失败: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    candidates,
+		"count":   len(candidates),
+	})
+}
+
+// getLearnedRules 获取自动学习的规则，可按 status（learned / confirmed / rejected）过滤
+func (a *Api) getLearnedRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rules, err := a.srv.GetLearnedRules(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("获取学习规则失败: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    rules,
+		"count":   len(rules),
+	})
+}
+
+// getDomainOutcome 获取域名在回退解析中的累计结果，作为审核学习规则的依据
+func (a *Api) getDomainOutcome(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	outcome, err := a.srv.GetDomainOutcome(chi.URLParam(r, "domain"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("获取域名解析结果失败: %v", err), http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    outcome,
+	})
+}
+
+// confirmLearnedRule 确认学习规则
+func (a *Api) confirmLearnedRule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rule, err := a.srv.ConfirmLearnedRule(chi.URLParam(r, "domain"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("确认学习规则失败: %v", err), http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "学习规则已确认",
+		"data":    rule,
+	})
+}
+
+// rejectLearnedRule 拒绝学习规则，规则立即失效且该域名不再自动学习
+func (a *Api) rejectLearnedRule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	rule, err := a.srv.RejectLearnedRule(chi.URLParam(r, "domain"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("拒绝学习规则失败: %v", err), http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "学习规则已拒绝",
+		"data":    rule,
+	})
+}
+
+// getUpstreams 获取上游熔断状态、失败计数与延迟分位数
+func (a *Api) getUpstreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	upstreams := a.srv.GetUpstreamStatus()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"data":    upstreams,
+		"count":   len(upstreams),
+	})
+}
+
+// resetUpstreams 手动闭合熔断器，未指定 address 时重置全部上游
+func (a *Api) resetUpstreams(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+
+	var req struct {
+		Address string `json:"address"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "无效的请求数据", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := a.srv.ResetUpstream(req.Address); err != nil {
+		http.Error(w, fmt.Sprintf("重置上游失败: %v", err), http.StatusNotFound)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "上游状态已重置",
+	})
+}
+
 // 获取延迟统计
 func (a *Api) getLatencyStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
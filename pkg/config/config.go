@@ -51,11 +51,21 @@ type Config struct {
 	} `yaml:"monitoring"`
 
 	// 安全配置
-	Security struct {
-		AdminToken string   `yaml:"admin_token"`
-		AllowedIPs []string `yaml:"allowed_ips"`
-		RateLimit  int      `yaml:"rate_limit"`
-	} `yaml:"security"`
+	Security Security `yaml:"security"`
+}
+
+// Security 安全配置：管理接口访问控制、客户端查询限速与 DNS 响应限速 (RRL)
+type Security struct {
+	AdminToken string   `yaml:"admin_token"`
+	AllowedIPs []string `yaml:"allowed_ips"`
+	RateLimit  int      `yaml:"rate_limit"` // 每个客户端前缀每秒查询数，0 表示不限制
+
+	RateLimitBurst     int      `yaml:"rate_limit_burst"`     // 查询突发容量，默认等于 rate_limit
+	ResponsesPerSecond int      `yaml:"responses_per_second"` // 每个 (客户端前缀, 查询名) 每秒响应数，0 表示不限制
+	Slip               int      `yaml:"slip"`                 // UDP 超限时每个桶每 N 次返回一次截断应答，默认 2，-1 表示全部丢弃
+	IPv4Prefix         int      `yaml:"ipv4_prefix"`          // 客户端归并前缀长度，默认 /24
+	IPv6Prefix         int      `yaml:"ipv6_prefix"`          // 默认 /56
+	Exempt             []string `yaml:"exempt"`               // 豁免的客户端 IP / CIDR
 }
 
 // LoadConfig 加载配置文件